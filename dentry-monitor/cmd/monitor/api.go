@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rophy/mem-psi-test/dentry-monitor/internal/cgroupmap"
+	"github.com/rophy/mem-psi-test/dentry-monitor/internal/metrics"
+	"github.com/rophy/mem-psi-test/dentry-monitor/internal/tracing"
+)
+
+// defaultCgroupsLimit is the page size of GET /cgroups when limit is omitted.
+const defaultCgroupsLimit = 500
+
+// handleCgroups lists resolver mappings in cgroup ID order, paginated and
+// optionally filtered by pod/container substring.
+// GET /cgroups?limit=&offset=&pod=&container=
+// → {"total": N, "offset": O, "limit": L, "cgroups": [{"cgroup_id", "pod", "container"}, ...]}
+// With ?format=ndjson (or Accept: application/x-ndjson) the page is streamed
+// as one JSON object per line and the total is sent in X-Total-Count.
+func handleCgroups(resolver *cgroupmap.Resolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+			return
+		}
+
+		q := r.URL.Query()
+		limit, offset := defaultCgroupsLimit, 0
+		for name, dst := range map[string]*int{"limit": &limit, "offset": &offset} {
+			if s := q.Get(name); s != "" {
+				n, err := strconv.Atoi(s)
+				if err != nil || n < 0 {
+					writeJSONError(w, http.StatusBadRequest, "invalid_parameter", name+" must be a non-negative integer")
+					return
+				}
+				*dst = n
+			}
+		}
+
+		var keep func(*cgroupmap.PodInfo) bool
+		pod, container := q.Get("pod"), q.Get("container")
+		if pod != "" || container != "" {
+			keep = func(info *cgroupmap.PodInfo) bool {
+				return strings.Contains(info.Pod, pod) && strings.Contains(info.Container, container)
+			}
+		}
+		page, total := resolver.Page(keep, offset, limit)
+
+		if q.Get("format") == "ndjson" || r.Header.Get("Accept") == "application/x-ndjson" {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("X-Total-Count", strconv.Itoa(total))
+			enc := json.NewEncoder(w)
+			for _, info := range page {
+				if err := enc.Encode(info); err != nil {
+					log.Printf("api: stream cgroups: %v", err)
+					return
+				}
+			}
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"total":   total,
+			"offset":  offset,
+			"limit":   limit,
+			"cgroups": page,
+		})
+	}
+}
+
+// handleSummary returns a per-container triage view from the last poll.
+// GET /summary?top=N → [{"cgroup_id", "pod", "container", "alloc", "positive",
+// "negative", "negative_ratio", "alloc_rate", "over_threshold"}, ...]
+// sorted by negative count descending.
+func handleSummary(collector *metrics.Collector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+			return
+		}
+
+		top := 0
+		if s := r.URL.Query().Get("top"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "top must be a non-negative integer")
+				return
+			}
+			top = n
+		}
+		writeJSON(w, http.StatusOK, collector.Summary(top))
+	}
+}
+
+// handleCgroupsRefresh triggers an immediate resolver scan.
+// POST /cgroups/refresh → {"mappings": N, "duration": "12ms"}
+func handleCgroupsRefresh(resolver *cgroupmap.Resolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+			return
+		}
+
+		mappings, took := resolver.Refresh()
+		log.Printf("api: resolver refresh requested by %s: %d mappings in %s", r.RemoteAddr, mappings, took)
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"mappings": mappings,
+			"duration": took.String(),
+		})
+	}
+}
+
+// handleMetricsReset zeroes BPF counters for testing. Destructive.
+// POST /metrics/reset?metric=reclaim|stats → {"metric": ..., "previous": ...}
+func handleMetricsReset(collector *metrics.Collector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+			return
+		}
+
+		metric := r.URL.Query().Get("metric")
+		var prev interface{}
+		var err error
+		switch metric {
+		case "reclaim":
+			prev, err = collector.ResetReclaim()
+		case "stats":
+			prev, err = collector.ResetStats()
+		default:
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter", `metric must be "reclaim" or "stats"`)
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "internal", err.Error())
+			return
+		}
+		log.Printf("api: %s metrics reset by %s", metric, r.RemoteAddr)
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"metric":   metric,
+			"previous": prev,
+		})
+	}
+}
+
+// patternTestRequest is the body of POST /traces/config/test.
+// Patterns and ExcludePatterns each default to the live config when omitted.
+type patternTestRequest struct {
+	Path            string   `json:"path"`
+	Patterns        []string `json:"patterns"`
+	ExcludePatterns []string `json:"exclude_patterns"`
+	Mode            string   `json:"mode"` // only "substring" (the default) is supported
+}
+
+// handleTraceConfigTest reports whether a path would pass the trace path
+// filter, without touching the live config.
+// POST /traces/config/test {"path": "...", "patterns": [...], "exclude_patterns": [...]}
+// → {"matched": bool, "pattern": "...", "excluded_by": "..."}
+func handleTraceConfigTest(consumer *tracing.Consumer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+			return
+		}
+
+		var req patternTestRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_json", "invalid JSON body: "+err.Error())
+			return
+		}
+		if req.Path == "" {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "path is required")
+			return
+		}
+		if req.Mode != "" && req.Mode != "substring" {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter", `unsupported mode "`+req.Mode+`": only "substring" is supported`)
+			return
+		}
+
+		live := consumer.Config()
+		patterns, exclude := req.Patterns, req.ExcludePatterns
+		if patterns == nil {
+			patterns = live.PathPatterns
+		}
+		if exclude == nil {
+			exclude = live.ExcludePatterns
+		}
+		for _, p := range [][]string{patterns, exclude} {
+			if err := tracing.ValidatePatterns(p); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid_pattern", err.Error())
+				return
+			}
+		}
+
+		matched, pattern, excludedBy := tracing.FilterPath(req.Path, patterns, exclude)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"matched":     matched,
+			"pattern":     pattern,
+			"excluded_by": excludedBy,
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("api: encode response: %v", err)
+	}
+}
+
+// apiError is the body of every API error response.
+type apiError struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// writeJSONError sends {"error": msg, "code": code} with the given status.
+// code is a stable machine-readable identifier; msg is for humans.
+func writeJSONError(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, apiError{Error: msg, Code: code})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Capability bit numbers from linux/capability.h.
+const (
+	capSysAdmin    = 21
+	capSysResource = 24
+	capPerfmon     = 38
+	capBPF         = 39
+)
+
+// checkCapabilities reads CapEff from /proc/self/status and returns an
+// actionable error if the process can't load BPF programs and attach
+// kprobes. That needs CAP_BPF and CAP_PERFMON (kernel 5.8+), or
+// CAP_SYS_ADMIN, which covers both. Kernels before 5.11 charge BPF memory
+// to RLIMIT_MEMLOCK instead of the memory cgroup, so raising it also needs
+// CAP_SYS_RESOURCE unless the limit is already unlimited. If CapEff can't
+// be read the check is skipped and loading reports whatever the kernel says.
+func checkCapabilities() error {
+	capEff, err := readCapEff("/proc/self/status")
+	if err != nil {
+		return nil
+	}
+	has := func(bit uint) bool { return capEff&(1<<bit) != 0 }
+
+	var problems, add []string
+	if !has(capSysAdmin) && !(has(capBPF) && has(capPerfmon)) {
+		var missing []string
+		if !has(capBPF) {
+			missing = append(missing, "CAP_BPF")
+			add = append(add, `"BPF"`)
+		}
+		if !has(capPerfmon) {
+			missing = append(missing, "CAP_PERFMON")
+			add = append(add, `"PERFMON"`)
+		}
+		problems = append(problems, fmt.Sprintf("missing %s (or CAP_SYS_ADMIN) needed to load BPF and attach kprobes",
+			strings.Join(missing, ", ")))
+	}
+	if !has(capSysResource) && memlockLimited() {
+		problems = append(problems, "missing CAP_SYS_RESOURCE needed to raise RLIMIT_MEMLOCK on kernels before 5.11")
+		add = append(add, `"SYS_RESOURCE"`)
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s; add them to securityContext.capabilities.add (e.g. [%s]) or run with privileged: true",
+		strings.Join(problems, "; "), strings.Join(add, ", "))
+}
+
+// memlockLimited reports whether loading BPF will need RLIMIT_MEMLOCK
+// raised: the kernel predates memcg accounting of BPF memory (5.11) and
+// the limit isn't already unlimited. An unreadable kernel release is
+// assumed to be recent.
+func memlockLimited() bool {
+	release, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil || !kernelBefore(string(release), 5, 11) {
+		return false
+	}
+	var lim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_MEMLOCK, &lim); err != nil {
+		return false
+	}
+	return lim.Cur != unix.RLIM_INFINITY || lim.Max != unix.RLIM_INFINITY
+}
+
+// kernelBefore reports whether a kernel release string such as
+// "5.10.0-21-amd64" is older than major.minor.
+func kernelBefore(release string, major, minor int) bool {
+	var gotMajor, gotMinor int
+	if _, err := fmt.Sscanf(strings.TrimSpace(release), "%d.%d", &gotMajor, &gotMinor); err != nil {
+		return false
+	}
+	return gotMajor < major || (gotMajor == major && gotMinor < minor)
+}
+
+// readCapEff returns the CapEff bitmask from a /proc/<pid>/status file.
+func readCapEff(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(scanner.Text(), "CapEff:"); ok {
+			return strconv.ParseUint(strings.TrimSpace(v), 16, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no CapEff in %s", path)
+}
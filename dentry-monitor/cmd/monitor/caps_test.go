@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadCapEff(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		want    uint64
+		wantErr bool
+	}{
+		{"full set", "Name:\tx\nCapInh:\t0000000000000000\nCapEff:\t000001ffffffffff\nCapBnd:\t000001ffffffffff\n", 0x1ffffffffff, false},
+		{"bpf and perfmon", "CapEff:\t000000c000000000\n", 1<<capBPF | 1<<capPerfmon, false},
+		{"sys_admin only", "CapEff:\t0000000000200000\n", 1 << capSysAdmin, false},
+		{"sys_resource only", "CapEff:\t0000000001000000\n", 1 << capSysResource, false},
+		{"none", "CapEff:\t0000000000000000\n", 0, false},
+		{"missing", "Name:\tx\nCapPrm:\t0000000000000000\n", 0, true},
+		{"not hex", "CapEff:\tzz\n", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "status")
+			if err := os.WriteFile(path, []byte(tt.status), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readCapEff(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readCapEff error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("readCapEff = %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKernelBefore(t *testing.T) {
+	tests := []struct {
+		release string
+		want    bool // older than 5.11
+	}{
+		{"5.10.0-21-amd64", true},
+		{"5.10.0-foo", true},
+		{"4.18.0-513.el8.x86_64\n", true},
+		{"5.11", false},
+		{"5.11.0", false},
+		{"5.15.0-91-generic", false},
+		{"6.1", false},
+		{"6.1.0-rc1+", false},
+		{"", false},
+		{"garbage", false},
+	}
+	for _, tt := range tests {
+		if got := kernelBefore(tt.release, 5, 11); got != tt.want {
+			t.Errorf("kernelBefore(%q, 5, 11) = %v, want %v", tt.release, got, tt.want)
+		}
+	}
+}
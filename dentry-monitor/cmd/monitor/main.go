@@ -1,17 +1,20 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/cilium/ebpf/link"
 	"github.com/cilium/ebpf/rlimit"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -24,21 +27,115 @@ import (
 
 func main() {
 	var (
+		showVersion     = flag.Bool("version", false, "Print version, commit and build date and exit")
 		listenAddr      = flag.String("listen", ":9090", "HTTP listen address")
+		uiEnabled       = flag.Bool("ui", false, "Serve a minimal web UI at / showing /summary")
+		adminListen     = flag.String("admin-listen", "", "Separate listen address for the control API (/cgroups*, /traces*, /metrics/reset); empty=serve on -listen")
+		logRequests     = flag.Bool("log-requests", false, "Log every HTTP request; mutating requests are always logged")
+		apiRate         = flag.Float64("api-rate-limit", 0, "Per-client requests/second allowed on the HTTP API, excluding /metrics and /healthz (0=unlimited)")
+		apiBurst        = flag.Int("api-rate-burst", 10, "Burst size for -api-rate-limit")
+		tlsCert         = flag.String("tls-cert", "", "TLS certificate file; enables HTTPS together with -tls-key (reloaded on SIGHUP)")
+		tlsKey          = flag.String("tls-key", "", "TLS private key file")
+		tlsClientCA     = flag.String("tls-client-ca", "", "CA bundle for verifying client certificates (enables mTLS)")
+		kprobeDAlloc    = flag.String("kprobe-d-alloc", "d_alloc", "Comma-separated kernel symbols tried in order for the d_alloc probes")
+		kprobeDInst     = flag.String("kprobe-d-instantiate", "d_instantiate", "Comma-separated kernel symbols tried in order for the d_instantiate probe")
+		kprobeShrink    = flag.String("kprobe-shrink-dcache-sb", "shrink_dcache_sb", "Comma-separated kernel symbols tried in order for the reclaim probe")
+		bpfStats        = flag.Bool("bpf-stats", false, "Enable kernel BPF run-time stats and export per-program run count and time (small node-wide cost)")
+		selftestMode    = flag.Bool("selftest", false, "Load BPF, attach and detach every probe, read every map, print a report and exit")
+		btfDir          = flag.String("btf-dir", "", "Directory of <kernel-release>.btf files used when the kernel has no built-in BTF")
 		procRoot        = flag.String("proc", "/proc", "Path to host /proc")
 		cgroupRoot      = flag.String("cgroup", "/sys/fs/cgroup", "Path to host cgroup filesystem")
+		metricPrefix    = flag.String("metric-prefix", metrics.DefaultPrefix, "Prefix for all exported metric names")
+		metricLabels    = flag.String("metric-labels", "", "Comma-separated key=value constant labels added to every metric (node defaults to $NODE_NAME)")
 		pollInterval    = flag.Duration("poll-interval", 5*time.Second, "BPF map poll interval")
+		pruneInterval   = flag.Duration("stats-prune-interval", 10*time.Minute, "Delete stats map entries for cgroups that no longer exist at startup and then this often (0=startup only)")
+		nodeStateOn     = flag.Bool("node-state", true, "Read /proc/sys/fs/dentry-state and export dentry_count (false skips the read entirely)")
+		nodeTimeout     = flag.Duration("node-state-timeout", 2*time.Second, "Max time a scrape waits on /proc/sys/fs/dentry-state (0=no limit)")
+		negThreshold    = flag.Float64("negative-ratio-threshold", 0.5, "Flag containers in /summary whose negative/(positive+negative) ratio exceeds this (0=never)")
+		dropUnresolved  = flag.Bool("drop-unresolved", false, "Omit per-container series for cgroups that don't resolve to a pod")
+		pollJitter      = flag.Float64("poll-jitter", 0.1, "Random jitter applied to poll and resolve intervals, as a fraction of the interval (0=off)")
 		resolveInterval = flag.Duration("resolve-interval", 30*time.Second, "Cgroup→pod resolve interval")
+		resolveMaxProcs = flag.Int("max-procs", 0, "Max pids scanned per resolver refresh; the next refresh resumes where it stopped (0=unlimited)")
+		resolveBudget   = flag.Duration("resolve-budget", 0, "Max wall time per resolver refresh (0=unlimited)")
+		resolveEvict    = flag.Int("resolve-evict-after", 3, "Drop a cgroup→pod mapping after this many consecutive full scans (or rotations of partial scans) without seeing it")
 		traceEnabled    = flag.Bool("trace-enabled", false, "Enable dentry path tracing on startup")
 		traceDir        = flag.String("trace-dir", "/data/traces", "Directory for trace TSV output files")
+		traceTTL        = flag.Duration("trace-disable-after", 0, "Turn tracing off automatically this long after it is enabled (0=never)")
+		traceNoFile     = flag.Bool("trace-no-file", false, "Count events for metrics only, with no event output; no trace directory or files are created")
 		traceMaxSizeMB  = flag.Int64("trace-max-size", 100, "Max trace file size in MB before rotation")
 		traceMaxFiles   = flag.Int("trace-max-files", 3, "Number of rotated trace files to keep")
+		traceDirMode    = flag.String("trace-dir-mode", "0755", "Permissions (octal) for the trace directory")
+		traceFileMode   = flag.String("trace-file-mode", "0644", "Permissions (octal) for trace files")
+		traceOwner      = flag.String("trace-owner", "", "Owner uid:gid for the trace directory and files (empty=unchanged)")
+		traceTemplate   = flag.String("trace-file-template", "traces.tsv", "Trace file name template; supports {node}, {date} (UTC start date, not updated on rotation) and {index}")
+		traceMaxEvent   = flag.Int("trace-max-event-size", 0, "Max bytes per trace row; longer rows drop host_path and truncate path (0=unlimited, else >= 256)")
+		traceTimeFormat = flag.String("trace-time-format", "rfc3339", "Trace timestamp format: rfc3339 or epoch (Unix nanoseconds)")
+		nodeName        = flag.String("node-name", os.Getenv("NODE_NAME"), "Node name used in templates (default $NODE_NAME, then hostname)")
 		tracePatterns   = flag.String("trace-patterns", "", "Comma-separated path substring filters (empty=all)")
+		traceExclude    = flag.String("trace-exclude-patterns", "", "Comma-separated path substrings to drop; applied after -trace-patterns and always wins")
+		traceClasses    = flag.String("trace-path-classes", "", "Comma-separated name=pattern path classes counted in dentry_trace_path_class_total (max 16)")
+		traceSample     = flag.Float64("trace-sample-rate", 1, "Fraction of trace events kept, for operations not in -trace-sample-rates")
+		traceSampleOps  = flag.String("trace-sample-rates", "", "Per-operation sample rates, e.g. alloc=0.01,negative=1")
+		traceNegOnly    = flag.Bool("trace-negative-only", false, "Trace only negative dentry instantiates (filtered in the kernel)")
+		traceTypes      = flag.String("trace-types", "", "Comma-separated file types to trace: dir, file, symlink, other, unknown (empty=all)")
+		traceCgroups    = flag.String("trace-cgroups", "", "Comma-separated cgroup IDs to trace (empty=all)")
+		tracePods       = flag.String("trace-pods", "", "Comma-separated resolved pod names (e.g. pod-1a2b3c4d-5e6) to trace (empty=all)")
+		traceHostPath   = flag.Bool("trace-host-path", false, "Fill host_path with the traced path under /proc/<pid>/root (valid only while the process lives; empty for negative dentries)")
+		traceReclaim    = flag.Bool("trace-reclaim-markers", false, "Inject a 'reclaim' marker event into the trace on each shrink_dcache_sb")
+		tracePoll       = flag.Duration("trace-poll-timeout", time.Second, "Max time a ring buffer read blocks before rechecking for shutdown (0=block)")
+		traceDedup      = flag.Duration("trace-dedup-window", 0, "Collapse alloc+instantiate pairs for the same cgroup and name within this window (0=off)")
+		traceEventKey   = flag.String("trace-event-key", "leaf", "How -trace-dedup-window groups events: leaf (cgroup+name) or cgroup+path")
+		traceWorkers    = flag.Int("trace-workers", 1, "Goroutines processing trace events; >1 keeps per-cgroup order but not global order")
+		traceQueueSize  = flag.Int("trace-queue-size", 1024, "Trace events buffered per worker before new ones are dropped")
 	)
 	flag.Parse()
 
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
+
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	log.Printf("dentry-monitor starting")
+	log.Printf("%s starting", versionString())
+
+	if *pollJitter < 0 || *pollJitter >= 1 {
+		log.Fatalf("invalid -poll-jitter %v: must be in [0, 1)", *pollJitter)
+	}
+	if *adminListen != "" && *adminListen == *listenAddr {
+		log.Fatalf("-admin-listen must differ from -listen")
+	}
+	if *apiRate < 0 || *apiBurst < 1 {
+		log.Fatalf("invalid -api-rate-limit %v / -api-rate-burst %d", *apiRate, *apiBurst)
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatalf("-tls-cert and -tls-key must be set together")
+	}
+	if *tlsClientCA != "" && *tlsCert == "" {
+		log.Fatalf("-tls-client-ca requires -tls-cert and -tls-key")
+	}
+	if !metricPrefixRe.MatchString(*metricPrefix) {
+		log.Fatalf("invalid -metric-prefix %q: must match %s", *metricPrefix, metricPrefixRe)
+	}
+	constLabels, err := parseLabels(*metricLabels)
+	if err != nil {
+		log.Fatalf("invalid -metric-labels: %v", err)
+	}
+	if _, ok := constLabels["node"]; !ok && *nodeName != "" {
+		constLabels["node"] = *nodeName
+	}
+	if *nodeName == "" {
+		*nodeName, _ = os.Hostname()
+	}
+
+	if *selftestMode {
+		os.Exit(runSelftest(*btfDir,
+			probeSymbols(*kprobeDAlloc), probeSymbols(*kprobeDInst), probeSymbols(*kprobeShrink)))
+	}
+
+	// Fail early with guidance instead of an opaque EPERM from the loader
+	if err := checkCapabilities(); err != nil {
+		log.Fatalf("%v", err)
+	}
 
 	// Remove memlock rlimit for eBPF
 	if err := rlimit.RemoveMemlock(); err != nil {
@@ -46,98 +143,233 @@ func main() {
 	}
 
 	// Load eBPF objects
-	objs, err := bpf.LoadObjects(nil)
+	loadOpts, btfSource, err := bpf.LoadOptions(*btfDir)
 	if err != nil {
-		log.Fatalf("failed to load eBPF objects: %v", err)
+		log.Fatalf("failed to find kernel BTF: %v", err)
 	}
-	defer objs.Close()
-
-	// Attach kprobes
-	kpDalloc, err := link.Kprobe("d_alloc", objs.TraceDAlloc(), nil)
-	if err != nil {
-		log.Fatalf("failed to attach kprobe/d_alloc: %v", err)
-	}
-	defer kpDalloc.Close()
-	log.Printf("attached kprobe/d_alloc")
-
-	kpDallocPath, err := link.Kprobe("d_alloc", objs.TraceDAllocPath(), nil)
+	log.Printf("using kernel BTF from %s", btfSource)
+	objs, err := bpf.LoadObjects(loadOpts)
 	if err != nil {
-		log.Printf("warning: failed to attach kprobe/d_alloc (tracing): %v", err)
-	} else {
-		defer kpDallocPath.Close()
-		log.Printf("attached kprobe/d_alloc (tracing)")
+		log.Fatalf("failed to load eBPF objects: %v", err)
 	}
+	defer objs.Close()
 
-	kpDinstantiate, err := link.Kprobe("d_instantiate", objs.TraceDInstantiate(), nil)
-	if err != nil {
-		log.Fatalf("failed to attach kprobe/d_instantiate: %v", err)
+	// Attach kprobes, trying each configured symbol in order
+	probes := &probeSet{}
+	defer probes.Close()
+	for _, spec := range monitorProbes(objs, probeSymbols(*kprobeDAlloc), probeSymbols(*kprobeDInst), probeSymbols(*kprobeShrink)) {
+		probes.attach(spec)
 	}
-	defer kpDinstantiate.Close()
-	log.Printf("attached kprobe/d_instantiate")
 
-	kpShrink, err := link.Kprobe("shrink_dcache_sb", objs.TraceShrinkDcache(), nil)
-	if err != nil {
-		log.Fatalf("failed to attach kprobe/shrink_dcache_sb: %v", err)
+	if *bpfStats {
+		if stats, err := bpf.EnableStats(); err != nil {
+			log.Printf("warning: failed to enable BPF stats, program run metrics only count while kernel.bpf_stats_enabled=1: %v", err)
+		} else {
+			defer stats.Close()
+			log.Printf("BPF run-time stats enabled")
+		}
+		prometheus.MustRegister(metrics.NewProgramStatsCollector(objs.Programs(), *metricPrefix, constLabels))
 	}
-	defer kpShrink.Close()
-	log.Printf("attached kprobe/shrink_dcache_sb")
 
 	// Start cgroup → pod resolver
-	resolver := cgroupmap.NewResolver(*procRoot, *cgroupRoot)
-	resolver.Start(*resolveInterval)
+	resolver := cgroupmap.NewResolver(*procRoot, *cgroupRoot, cgroupmap.Options{
+		MaxProcs:   *resolveMaxProcs,
+		ScanBudget: *resolveBudget,
+		EvictAfter: *resolveEvict,
+	})
+	resolver.Start(*resolveInterval, *pollJitter)
 	defer resolver.Stop()
 
 	// Start metrics collector
-	collector := metrics.NewCollector(objs.DentryStatsMap(), objs.ReclaimCount(), resolver, *procRoot)
+	collector := metrics.NewCollector(objs.DentryStatsMap(), objs.ReclaimCount(), objs.AllocLatencyHist(), resolver, *procRoot, metrics.Options{
+		NodeStateTimeout:       *nodeTimeout,
+		DisableNodeState:       !*nodeStateOn,
+		DropUnresolved:         *dropUnresolved,
+		NegativeRatioThreshold: *negThreshold,
+		Prefix:                 *metricPrefix,
+		ConstLabels:            constLabels,
+	})
 	prometheus.MustRegister(collector)
 
 	stopCh := make(chan struct{})
 
-	go collector.Start(*pollInterval, stopCh)
-	log.Printf("metrics collector started (poll every %s)", *pollInterval)
+	go collector.Start(*pollInterval, *pollJitter, stopCh)
+	log.Printf("metrics collector started (poll every %s, jitter %.0f%%)", *pollInterval, *pollJitter*100)
+	go collector.StartPruning(*pruneInterval, stopCh)
 
 	// Build trace config
 	traceCfg := tracing.TraceConfig{
-		Enabled: *traceEnabled,
+		Enabled:         *traceEnabled,
+		ReclaimMarkers:  *traceReclaim,
+		ResolveHostPath: *traceHostPath,
+		ProcRoot:        *procRoot,
+		DedupWindow:     *traceDedup,
+		EventKey:        *traceEventKey,
+		NegativeOnly:    *traceNegOnly,
+		Workers:         *traceWorkers,
+		QueueSize:       *traceQueueSize,
+		PollTimeout:     *tracePoll,
+		DisableAfter:    *traceTTL,
+		MetricPrefix:    *metricPrefix,
+		MetricLabels:    constLabels,
 	}
 	if *tracePatterns != "" {
 		traceCfg.PathPatterns = strings.Split(*tracePatterns, ",")
 	}
-
-	// Create TSV writer
-	tsvWriter, err := tracing.NewTSVWriter(*traceDir, *traceMaxSizeMB*1024*1024, *traceMaxFiles)
+	if *traceExclude != "" {
+		traceCfg.ExcludePatterns = strings.Split(*traceExclude, ",")
+	}
+	if *traceTypes != "" {
+		for _, t := range strings.Split(*traceTypes, ",") {
+			if !slices.Contains(tracing.EventTypes, t) {
+				log.Fatalf("invalid -trace-types entry %q: must be one of %v", t, tracing.EventTypes)
+			}
+			traceCfg.Types = append(traceCfg.Types, t)
+		}
+	}
+	if !slices.Contains(tracing.EventKeys, *traceEventKey) {
+		log.Fatalf("invalid -trace-event-key %q: must be one of %v", *traceEventKey, tracing.EventKeys)
+	}
+	if *traceMaxEvent != 0 && *traceMaxEvent < 256 {
+		log.Fatalf("invalid -trace-max-event-size %d: must be 0 or at least 256", *traceMaxEvent)
+	}
+	if *traceWorkers < 1 {
+		log.Fatalf("invalid -trace-workers %d: must be at least 1", *traceWorkers)
+	}
+	if *traceQueueSize < 1 {
+		log.Fatalf("invalid -trace-queue-size %d: must be at least 1", *traceQueueSize)
+	}
+	if *traceSample <= 0 || *traceSample > 1 {
+		log.Fatalf("invalid -trace-sample-rate %v: must be in (0, 1]", *traceSample)
+	}
+	traceCfg.SampleRate = *traceSample
+	traceCfg.SampleRates, err = tracing.ParseSampleRates(*traceSampleOps)
 	if err != nil {
-		log.Fatalf("failed to create TSV writer: %v", err)
+		log.Fatalf("invalid -trace-sample-rates: %v", err)
+	}
+	traceCfg.PathClasses, err = tracing.ParsePathClasses(*traceClasses)
+	if err != nil {
+		log.Fatalf("invalid -trace-path-classes: %v", err)
+	}
+	if *traceCgroups != "" {
+		for _, s := range strings.Split(*traceCgroups, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				log.Fatalf("invalid -trace-cgroups entry %q: %v", s, err)
+			}
+			traceCfg.CgroupIDs = append(traceCfg.CgroupIDs, id)
+		}
+	}
+	if *tracePods != "" {
+		traceCfg.Pods = strings.Split(*tracePods, ",")
+	}
+
+	// Create TSV writer (skipped entirely with -trace-no-file)
+	var tsvWriter *tracing.TSVWriter
+	writerCfg := tracing.WriterConfig{
+		Dir:      *traceDir,
+		MaxSize:  *traceMaxSizeMB * 1024 * 1024,
+		MaxFiles: *traceMaxFiles,
+		UID:      -1,
+		GID:      -1,
+
+		FileTemplate: *traceTemplate,
+		TimeFormat:   *traceTimeFormat,
+		NodeName:     *nodeName,
+		MaxEventSize: *traceMaxEvent,
+	}
+	if !*traceNoFile {
+		// Modes are only enforced on existing paths when set explicitly;
+		// the defaults just apply to paths the writer creates.
+		explicit := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+		if explicit["trace-dir-mode"] {
+			if writerCfg.DirMode, err = parseMode(*traceDirMode); err != nil {
+				log.Fatalf("invalid -trace-dir-mode: %v", err)
+			}
+		}
+		if explicit["trace-file-mode"] {
+			if writerCfg.FileMode, err = parseMode(*traceFileMode); err != nil {
+				log.Fatalf("invalid -trace-file-mode: %v", err)
+			}
+		}
+		if *traceOwner != "" {
+			if writerCfg.UID, writerCfg.GID, err = parseOwner(*traceOwner); err != nil {
+				log.Fatalf("invalid -trace-owner: %v", err)
+			}
+		}
+		tsvWriter, err = tracing.NewTSVWriter(writerCfg)
+		if err != nil {
+			log.Fatalf("failed to create TSV writer: %v", err)
+		}
 	}
 
 	// Start trace consumer
-	consumer, err := tracing.NewConsumer(objs.TraceEvents(), objs.TraceConfigMap(), resolver, traceCfg, tsvWriter)
+	consumer, err := tracing.NewConsumer(objs.TraceEvents(), objs.TraceConfigMap(), objs.TraceCgroups(), resolver, traceCfg, tsvWriter)
 	if err != nil {
 		log.Fatalf("failed to create trace consumer: %v", err)
 	}
+	prometheus.MustRegister(consumer)
 	go consumer.Start(stopCh)
-	log.Printf("trace consumer started (dir=%s, max_size=%dMB, max_files=%d, enabled=%v)",
-		*traceDir, *traceMaxSizeMB, *traceMaxFiles, *traceEnabled)
+	if *traceNoFile {
+		log.Printf("trace consumer started (no file output, events only counted, enabled=%v)", *traceEnabled)
+	} else {
+		log.Printf("trace consumer started (dir=%s, max_size=%dMB, max_files=%d, enabled=%v)",
+			*traceDir, *traceMaxSizeMB, *traceMaxFiles, *traceEnabled)
+	}
 
-	// HTTP server
+	// HTTP servers: scrape endpoints on -listen, the control API on
+	// -admin-listen when set (otherwise on the same mux)
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
 
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, "ok")
 	})
+	mux.HandleFunc("/summary", handleSummary(collector))
+	if *uiEnabled {
+		mux.HandleFunc("GET /{$}", handleUI)
+	}
 
-	server := &http.Server{
-		Addr:    *listenAddr,
-		Handler: mux,
+	adminMux := mux
+	if *adminListen != "" {
+		adminMux = http.NewServeMux()
 	}
+	adminMux.HandleFunc("/cgroups", handleCgroups(resolver))
+	adminMux.HandleFunc("/cgroups/refresh", handleCgroupsRefresh(resolver))
+	adminMux.HandleFunc("/metrics/reset", handleMetricsReset(collector))
+	adminMux.HandleFunc("/traces/config/test", handleTraceConfigTest(consumer))
 
-	go func() {
-		log.Printf("HTTP server listening on %s", *listenAddr)
-		if err := server.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalf("HTTP server error: %v", err)
+	var tlsConfig *tls.Config
+	if *tlsCert != "" {
+		certs, err := newCertReloader(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalf("failed to load TLS certificate: %v", err)
+		}
+		tlsConfig, err = newTLSConfig(certs, *tlsClientCA)
+		if err != nil {
+			log.Fatalf("failed to configure TLS: %v", err)
 		}
-	}()
+		go certs.watchSIGHUP(stopCh)
+	}
+
+	apiMetrics := newHTTPMetrics(*metricPrefix, constLabels)
+	prometheus.MustRegister(apiMetrics)
+
+	handler := func(h http.Handler) http.Handler {
+		if *apiRate > 0 {
+			h = newRateLimiter(*apiRate, *apiBurst, apiMetrics.limited).wrap(h)
+		}
+		return apiMetrics.wrap(h, *logRequests)
+	}
+
+	servers := []*http.Server{{Addr: *listenAddr, Handler: handler(mux), TLSConfig: tlsConfig}}
+	if *adminListen != "" {
+		servers = append(servers, &http.Server{Addr: *adminListen, Handler: handler(adminMux), TLSConfig: tlsConfig})
+	}
+	for _, server := range servers {
+		go serve(server)
+	}
 
 	// Wait for signal
 	sigCh := make(chan os.Signal, 1)
@@ -147,5 +379,95 @@ func main() {
 
 	close(stopCh)
 	consumer.Close()
-	server.Close()
+	for _, server := range servers {
+		server.Close()
+	}
+}
+
+// serve runs server until it is closed, using TLS when TLSConfig is set.
+func serve(server *http.Server) {
+	var err error
+	if server.TLSConfig != nil {
+		log.Printf("HTTPS server listening on %s (client certs required: %v)",
+			server.Addr, server.TLSConfig.ClientAuth == tls.RequireAndVerifyClientCert)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		log.Printf("HTTP server listening on %s", server.Addr)
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		log.Fatalf("HTTP server error on %s: %v", server.Addr, err)
+	}
+}
+
+// metricPrefixRe is the Prometheus metric name rule, minus the colons
+// reserved for recording rules.
+var metricPrefixRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels are the variable label names used by some metric, plus the
+// histogram bucket label; a constant label with the same name would make
+// registration panic at startup. Keep in sync when adding labelled metrics.
+var reservedLabels = map[string]bool{
+	"pod":        true,
+	"container":  true,
+	"type":       true,
+	"operation":  true,
+	"path_class": true,
+	"kind":       true,
+	"reason":     true,
+	"path":       true,
+	"method":     true,
+	"code":       true,
+	"program":    true,
+	"le":         true,
+}
+
+// labelNameRe is the Prometheus label name rule.
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// parseLabels parses "k1=v1,k2=v2" into constant labels.
+func parseLabels(s string) (prometheus.Labels, error) {
+	labels := prometheus.Labels{}
+	if s == "" {
+		return labels, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		k = strings.TrimSpace(k)
+		if !ok || v == "" {
+			return nil, fmt.Errorf("%q: expected key=value", pair)
+		}
+		if !labelNameRe.MatchString(k) || strings.HasPrefix(k, "__") {
+			return nil, fmt.Errorf("%q: invalid label name", k)
+		}
+		if reservedLabels[k] {
+			return nil, fmt.Errorf("%q: label name already used by a metric", k)
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+// parseMode parses an octal permission string such as "0750".
+func parseMode(s string) (os.FileMode, error) {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an octal mode", s)
+	}
+	return os.FileMode(v), nil
+}
+
+// parseOwner parses a numeric "uid:gid" pair.
+func parseOwner(s string) (uid, gid int, err error) {
+	u, g, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("%q is not uid:gid", s)
+	}
+	if uid, err = strconv.Atoi(u); err != nil {
+		return 0, 0, fmt.Errorf("bad uid %q", u)
+	}
+	if gid, err = strconv.Atoi(g); err != nil {
+		return 0, 0, fmt.Errorf("bad gid %q", g)
+	}
+	return uid, gid, nil
 }
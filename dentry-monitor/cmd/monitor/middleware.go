@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// httpMetrics instruments the HTTP API.
+type httpMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	limited  prometheus.Counter
+}
+
+func newHTTPMetrics(prefix string, labels prometheus.Labels) *httpMetrics {
+	return &httpMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        prefix + "_http_requests_total",
+			Help:        "HTTP requests by route, method and status code",
+			ConstLabels: labels,
+		}, []string{"path", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        prefix + "_http_request_duration_seconds",
+			Help:        "HTTP request latency by route",
+			Buckets:     prometheus.ExponentialBuckets(0.0005, 2, 14), // 0.5ms .. ~4s
+			ConstLabels: labels,
+		}, []string{"path"}),
+		limited: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        prefix + "_http_rate_limited_total",
+			Help:        "HTTP requests rejected with 429 by the API rate limiter",
+			ConstLabels: labels,
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *httpMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.duration.Describe(ch)
+	m.limited.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *httpMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.duration.Collect(ch)
+	m.limited.Collect(ch)
+}
+
+// wrap counts and times every request served by next. Mutating requests
+// (anything but GET/HEAD) are always logged; reads only when logReads is set.
+// The path label is the matched route pattern, so unknown URLs can't blow up
+// cardinality.
+func (m *httpMetrics) wrap(next http.Handler, logReads bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		took := time.Since(start)
+
+		path := r.Pattern
+		if path == "" {
+			path = "unmatched"
+		}
+		m.requests.WithLabelValues(path, r.Method, strconv.Itoa(rec.status)).Inc()
+		m.duration.WithLabelValues(path).Observe(took.Seconds())
+
+		if logReads || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			log.Printf("http: %s %s %d %v from %s", r.Method, r.URL.RequestURI(), rec.status, took, r.RemoteAddr)
+		}
+	})
+}
+
+// statusRecorder captures the response status code.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
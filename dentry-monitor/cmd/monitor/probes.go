@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+
+	bpf "github.com/rophy/mem-psi-test/dentry-monitor/internal/ebpf"
+)
+
+// probeSpec describes one kprobe the monitor attaches. Optional probes only
+// feed tracing or latency; the counters need the required ones.
+type probeSpec struct {
+	name     string // for log lines
+	symbols  []string
+	prog     *ebpf.Program
+	ret      bool
+	required bool
+}
+
+// monitorProbes lists every probe, given the symbol candidates per function.
+func monitorProbes(objs *bpf.Objects, dAlloc, dInst, shrink []string) []probeSpec {
+	return []probeSpec{
+		{"d_alloc", dAlloc, objs.TraceDAlloc(), false, true},
+		{"d_alloc (latency)", dAlloc, objs.TraceDAllocRet(), true, false},
+		{"d_instantiate", dInst, objs.TraceDInstantiate(), false, true},
+		{"shrink_dcache_sb", shrink, objs.TraceShrinkDcache(), false, true},
+	}
+}
+
+func (s probeSpec) kind() string {
+	if s.ret {
+		return "kretprobe"
+	}
+	return "kprobe"
+}
+
+// attachKprobe attaches prog to the first of symbols that the kernel accepts,
+// so renamed functions (e.g. "d_alloc.constprop.0") can be listed as
+// fallbacks. It returns the link and the symbol that was used.
+func attachKprobe(symbols []string, prog *ebpf.Program, ret bool) (link.Link, string, error) {
+	var errs []error
+	for _, sym := range symbols {
+		var l link.Link
+		var err error
+		if ret {
+			l, err = link.Kretprobe(sym, prog, nil)
+		} else {
+			l, err = link.Kprobe(sym, prog, nil)
+		}
+		if err == nil {
+			return l, sym, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", sym, err))
+	}
+	return nil, "", errors.Join(errs...)
+}
+
+// probeSymbols splits a comma-separated symbol list from a flag.
+func probeSymbols(s string) []string {
+	var out []string
+	for _, sym := range strings.Split(s, ",") {
+		if sym = strings.TrimSpace(sym); sym != "" {
+			out = append(out, sym)
+		}
+	}
+	return out
+}
+
+// probeSet attaches kprobes and closes them all on shutdown.
+type probeSet struct {
+	links []link.Link
+}
+
+// attach attaches a probe. Failures are fatal when the probe is required,
+// otherwise logged as a warning and skipped.
+func (p *probeSet) attach(s probeSpec) {
+	l, sym, err := attachKprobe(s.symbols, s.prog, s.ret)
+	if err != nil {
+		if s.required {
+			log.Fatalf("failed to attach %s/%s: %v", s.kind(), s.name, err)
+		}
+		log.Printf("warning: failed to attach %s/%s: %v", s.kind(), s.name, err)
+		return
+	}
+	p.links = append(p.links, l)
+	log.Printf("attached %s/%s (symbol %s)", s.kind(), s.name, sym)
+}
+
+func (p *probeSet) Close() {
+	for _, l := range p.links {
+		l.Close()
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/ringbuf"
+	"github.com/cilium/ebpf/rlimit"
+
+	bpf "github.com/rophy/mem-psi-test/dentry-monitor/internal/ebpf"
+)
+
+// selftest collects check results for -selftest.
+type selftest struct {
+	failed bool
+}
+
+func (t *selftest) report(check string, err error, required bool) {
+	switch {
+	case err == nil:
+		fmt.Printf("PASS  %s\n", check)
+	case required:
+		t.failed = true
+		fmt.Printf("FAIL  %s: %v\n", check, err)
+	default:
+		fmt.Printf("WARN  %s: %v\n", check, err)
+	}
+}
+
+// runSelftest checks capabilities, loads the BPF objects, attaches and detaches every probe, reads
+// every map once and prints a report. It returns the process exit code:
+// non-zero if loading, a required probe or a map read failed. The optional
+// latency probe only warns, matching how the monitor itself runs.
+func runSelftest(btfDir string, dAlloc, dInst, shrink []string) int {
+	t := &selftest{}
+
+	err := checkCapabilities()
+	t.report("capabilities", err, true)
+	if err != nil {
+		return 1
+	}
+	err = rlimit.RemoveMemlock()
+	t.report("remove memlock rlimit", err, true)
+	if err != nil {
+		return 1
+	}
+	loadOpts, btfSource, err := bpf.LoadOptions(btfDir)
+	t.report("find kernel BTF", err, true)
+	if err != nil {
+		return 1
+	}
+
+	objs, err := bpf.LoadObjects(loadOpts)
+	t.report("load eBPF objects (BTF: "+btfSource+")", err, true)
+	if err != nil {
+		return 1
+	}
+	defer objs.Close()
+
+	for _, spec := range monitorProbes(objs, dAlloc, dInst, shrink) {
+		l, sym, err := attachKprobe(spec.symbols, spec.prog, spec.ret)
+		check := fmt.Sprintf("attach %s/%s", spec.kind(), spec.name)
+		if err == nil {
+			check += " (symbol " + sym + ")"
+			l.Close()
+		}
+		t.report(check, err, spec.required)
+	}
+
+	for _, m := range []struct {
+		name string
+		m    *ebpf.Map
+	}{
+		{"dentry_stats_map", objs.DentryStatsMap()},
+		{"alloc_latency_hist", objs.AllocLatencyHist()},
+		{"reclaim_count", objs.ReclaimCount()},
+		{"trace_config_map", objs.TraceConfigMap()},
+		{"trace_cgroups", objs.TraceCgroups()},
+	} {
+		t.report("read "+m.name, readFirstKey(m.m), true)
+	}
+	rd, err := ringbuf.NewReader(objs.TraceEvents())
+	if err == nil {
+		rd.Close()
+	}
+	t.report("open trace_events ring buffer", err, true)
+
+	if t.failed {
+		fmt.Println("selftest FAILED")
+		return 1
+	}
+	fmt.Println("selftest passed")
+	return 0
+}
+
+// readFirstKey reads one key from m, which is enough to prove the map is
+// accessible regardless of its value layout. An empty map is fine.
+func readFirstKey(m *ebpf.Map) error {
+	key := make([]byte, m.KeySize())
+	if err := m.NextKey(nil, key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+)
+
+//go:embed ui/index.html
+var uiFS embed.FS
+
+// handleUI serves the embedded page that renders /summary as a live table.
+// GET /
+func handleUI(w http.ResponseWriter, r *http.Request) {
+	http.ServeFileFS(w, r, uiFS, "ui/index.html")
+}
@@ -0,0 +1,67 @@
+package cgroupmap
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// initPidNS is the readlink of /proc/<pid>/ns/pid for processes in the
+// initial (host) PID namespace (PROC_PID_INIT_INO).
+const initPidNS = "pid:[4026531836]"
+
+// ProcMode describes what the resolver's proc root turned out to be.
+type ProcMode struct {
+	// HostPID is true when procRoot shows the host PID namespace, so pids
+	// match the ones reported by BPF and every container is visible.
+	HostPID bool
+	// RelativeCgroups is true when cgroup paths are printed relative to a
+	// private cgroup namespace ("/../../.."), so the absolute path has to
+	// be reconstructed.
+	RelativeCgroups bool
+}
+
+func (m ProcMode) String() string {
+	pid, cg := "container PID namespace", "absolute cgroup paths"
+	if m.HostPID {
+		pid = "host PID namespace"
+	}
+	if m.RelativeCgroups {
+		cg = "relative cgroup paths"
+	}
+	return pid + ", " + cg
+}
+
+// detectProcMode inspects pid 1 under procRoot. Its PID namespace tells
+// whether procRoot is the host /proc; a cgroup path starting with "/.."
+// means the monitor runs in its own cgroup namespace.
+func detectProcMode(procRoot string) ProcMode {
+	var m ProcMode
+	cg := readCgroupV2Line(filepath.Join(procRoot, "1", "cgroup"))
+	if ns, err := os.Readlink(filepath.Join(procRoot, "1", "ns", "pid")); err == nil {
+		m.HostPID = ns == initPidNS
+	} else {
+		// Can't read the namespace link: pid 1 of the host is never in a pod.
+		m.HostPID = cg != "" && !strings.Contains(cg, "kubepods")
+	}
+	m.RelativeCgroups = strings.HasPrefix(cg, "/..")
+	return m
+}
+
+// readCgroupV2Line returns the path of the "0::" line of a cgroup file.
+func readCgroupV2Line(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::")
+		}
+	}
+	return ""
+}
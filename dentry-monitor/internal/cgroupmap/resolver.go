@@ -1,57 +1,101 @@
 package cgroupmap
 
 import (
-	"bufio"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/rophy/mem-psi-test/dentry-monitor/internal/jitter"
 )
 
 // PodInfo holds resolved pod metadata for a cgroup ID.
 type PodInfo struct {
-	Pod       string
-	Container string
-	CgroupID  uint64
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	CgroupID  uint64 `json:"cgroup_id"`
+}
+
+// Options bounds the cost of a single /proc scan.
+type Options struct {
+	// MaxProcs caps the number of pids scanned per refresh (0=unlimited).
+	MaxProcs int
+	// ScanBudget caps the wall time of a single refresh (0=unlimited).
+	ScanBudget time.Duration
+	// EvictAfter is the number of consecutive complete scans a cgroup may
+	// go unseen before its mapping is dropped (<=1 evicts on the first miss).
+	EvictAfter int
 }
 
 // Resolver maps kernel cgroup IDs to Kubernetes pod metadata.
 // It works by scanning /proc/<pid>/cgroup and matching against
 // known cgroup paths from /sys/fs/cgroup.
 type Resolver struct {
+	refreshMu sync.Mutex // serializes refresh (ticker vs on-demand)
+
 	mu       sync.RWMutex
 	cache    map[uint64]*PodInfo // cgroup_id → pod info
+	misses   map[uint64]int      // cgroup_id → consecutive scans not seen
+	onRefresh []func()           // called after every refresh
 	procRoot string             // usually "/proc" (or host-mounted path)
 	cgRoot   string             // usually "/sys/fs/cgroup"
+	opts     Options
+	mode     ProcMode
 	stopCh   chan struct{}
+
+	// Rotation state, guarded by refreshMu.
+	cursor string          // last pid scanned; the next scan resumes after it
+	seen   map[uint64]bool // cgroups seen during the current rotation
+
+	partialScans atomic.Uint64 // refreshes cut short by opts limits
 }
 
 // NewResolver creates a resolver that scans the host proc and cgroup
 // filesystems. Pass the paths where they are mounted in the container
 // (e.g. /host/proc, /host/sys/fs/cgroup).
-func NewResolver(procRoot, cgRoot string) *Resolver {
+func NewResolver(procRoot, cgRoot string, opts Options) *Resolver {
+	mode := detectProcMode(procRoot)
+	log.Printf("resolver: %s: %s", procRoot, mode)
+	if !mode.HostPID {
+		log.Printf("warning: resolver: %s is not the host /proc; only this pod's processes are visible. "+
+			"Run with hostPID: true and mount the host /proc", procRoot)
+	}
 	return &Resolver{
 		cache:    make(map[uint64]*PodInfo),
+		misses:   make(map[uint64]int),
+		seen:     make(map[uint64]bool),
 		procRoot: procRoot,
 		cgRoot:   cgRoot,
+		opts:     opts,
+		mode:     mode,
 		stopCh:   make(chan struct{}),
 	}
 }
 
+// Mode returns what was detected about the proc root at startup.
+func (r *Resolver) Mode() ProcMode {
+	return r.mode
+}
+
 // Start begins periodic scanning. Call Stop() to terminate.
-func (r *Resolver) Start(interval time.Duration) {
+// jitterFrac randomizes each interval by up to ±jitterFrac and offsets the
+// first tick so that resolvers across nodes don't scan in lockstep.
+func (r *Resolver) Start(interval time.Duration, jitterFrac float64) {
 	r.refresh()
 	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
+		timer := time.NewTimer(jitter.Offset(interval, jitterFrac))
+		defer timer.Stop()
 		for {
 			select {
-			case <-ticker.C:
+			case <-timer.C:
 				r.refresh()
+				timer.Reset(jitter.Duration(interval, jitterFrac))
 			case <-r.stopCh:
 				return
 			}
@@ -71,6 +115,13 @@ func (r *Resolver) Resolve(cgroupID uint64) *PodInfo {
 	return r.cache[cgroupID]
 }
 
+// Len returns the number of known mappings.
+func (r *Resolver) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.cache)
+}
+
 // Snapshot returns a copy of all known mappings.
 func (r *Resolver) Snapshot() map[uint64]*PodInfo {
 	r.mu.RLock()
@@ -82,28 +133,154 @@ func (r *Resolver) Snapshot() map[uint64]*PodInfo {
 	return out
 }
 
+// Page returns the mappings accepted by keep (nil keeps all) in ascending
+// cgroup ID order, skipping the first offset matches and returning at most
+// limit (0 = no limit), plus the total number of matches. Only pointers are
+// copied under the read lock; cached entries are never modified in place.
+func (r *Resolver) Page(keep func(*PodInfo) bool, offset, limit int) (page []*PodInfo, total int) {
+	r.mu.RLock()
+	ids := make([]uint64, 0, len(r.cache))
+	for id, info := range r.cache {
+		if keep == nil || keep(info) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	total = len(ids)
+	if offset > total {
+		offset = total
+	}
+	ids = ids[offset:]
+	if limit > 0 && limit < len(ids) {
+		ids = ids[:limit]
+	}
+	page = make([]*PodInfo, len(ids))
+	for i, id := range ids {
+		page[i] = r.cache[id]
+	}
+	r.mu.RUnlock()
+	return page, total
+}
+
+// OnRefresh registers fn to be called after every refresh, once the new
+// mappings are visible to Resolve and Snapshot.
+func (r *Resolver) OnRefresh(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onRefresh = append(r.onRefresh, fn)
+}
+
+// Refresh runs an immediate scan and returns the resulting number of
+// mappings and how long the scan took. Concurrent callers (including the
+// periodic scan) are serialized rather than run in parallel.
+func (r *Resolver) Refresh() (mappings int, took time.Duration) {
+	start := time.Now()
+	mappings = r.refresh()
+	return mappings, time.Since(start)
+}
+
+// PartialScans returns the number of refreshes that stopped early because
+// they hit the MaxProcs or ScanBudget limit.
+func (r *Resolver) PartialScans() uint64 {
+	return r.partialScans.Load()
+}
+
+// LiveCgroupIDs walks the cgroup filesystem and returns the IDs (directory
+// inode numbers) of every cgroup that currently exists. It refuses to run
+// unless the cgroup root is the root of a cgroup v2 hierarchy, since a
+// namespaced or v1 mount would make live cgroups look dead.
+func (r *Resolver) LiveCgroupIDs() (map[uint64]bool, error) {
+	if err := checkCgroup2Root(r.cgRoot); err != nil {
+		return nil, err
+	}
+	live := make(map[uint64]bool)
+	err := filepath.WalkDir(r.cgRoot, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			// Cgroups removed mid-walk are expected; anything else aborts.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if ino, ok := statIno(info); ok {
+			live[ino] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk %s: %w", r.cgRoot, err)
+	}
+	return live, nil
+}
+
 // refresh scans /proc to build cgroup_id → pod mapping.
 // For cgroup v2 (unified hierarchy), we stat the cgroup directory
 // to get the inode number which matches bpf_get_current_cgroup_id().
-func (r *Resolver) refresh() {
+//
+// Results are merged over the existing cache rather than replacing it, so a
+// cgroup whose processes are momentarily missed keeps its labels. If the
+// scan hits the configured MaxProcs or ScanBudget limit it is partial, and
+// the next scan resumes after the last pid visited, wrapping around, so
+// limited scans still rotate through every pid. Each pass over the whole
+// pid list (a rotation, in one scan or across several partial ones) counts
+// as one complete scan: entries not seen during it get a miss, and are
+// evicted after EvictAfter consecutive misses.
+//
+// Returns the number of mappings in the cache after the scan.
+func (r *Resolver) refresh() int {
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+
 	newCache := make(map[uint64]*PodInfo)
+	start := time.Now()
+	scanned := 0
+	partial := false
+	evicted := 0
 
 	entries, err := os.ReadDir(r.procRoot)
 	if err != nil {
 		log.Printf("resolver: cannot read %s: %v", r.procRoot, err)
-		return
+		return r.Len()
 	}
-
+	// ReadDir sorts by name, which gives a stable order to resume in.
+	var pids []string
 	for _, entry := range entries {
 		if !entry.IsDir() {
 			continue
 		}
-		pid, err := strconv.Atoi(entry.Name())
-		if err != nil || pid == 0 {
+		if pid, err := strconv.Atoi(entry.Name()); err != nil || pid == 0 {
 			continue
 		}
+		pids = append(pids, entry.Name())
+	}
+
+	i := sort.SearchStrings(pids, r.cursor)
+	if i < len(pids) && pids[i] == r.cursor {
+		i++
+	}
+	for n := 0; n < len(pids); n++ {
+		if i == len(pids) {
+			evicted += r.endRotation()
+			i = 0
+		}
+		if (r.opts.MaxProcs > 0 && scanned >= r.opts.MaxProcs) ||
+			(r.opts.ScanBudget > 0 && time.Since(start) >= r.opts.ScanBudget) {
+			partial = true
+			break
+		}
+		name := pids[i]
+		r.cursor = name
+		i++
+		scanned++
 
-		cgroupPath := filepath.Join(r.procRoot, entry.Name(), "cgroup")
+		cgroupPath := filepath.Join(r.procRoot, name, "cgroup")
 		cgDir := r.parseCgroupV2(cgroupPath)
 		if cgDir == "" {
 			continue
@@ -132,52 +309,88 @@ func (r *Resolver) refresh() {
 
 		info.CgroupID = sys
 		newCache[sys] = info
+		r.seen[sys] = true
 	}
 
 	r.mu.Lock()
-	r.cache = newCache
+	for k, v := range newCache {
+		r.cache[k] = v
+		delete(r.misses, k)
+	}
 	r.mu.Unlock()
+	if i == len(pids) {
+		evicted += r.endRotation()
+	}
+
+	r.mu.RLock()
+	total := len(r.cache)
+	hooks := r.onRefresh
+	r.mu.RUnlock()
+
+	for _, fn := range hooks {
+		fn()
+	}
 
-	log.Printf("resolver: refreshed, %d cgroup→pod mappings", len(newCache))
+	if partial {
+		r.partialScans.Add(1)
+		log.Printf("resolver: partial scan (%d pids in %s), merged %d mappings, %d total (%d evicted)",
+			scanned, time.Since(start).Round(time.Millisecond), len(newCache), total, evicted)
+		return total
+	}
+	log.Printf("resolver: refreshed, %d cgroup→pod mappings (%d evicted)", total, evicted)
+	return total
+}
+
+// endRotation closes a full pass over the pid list: every cached cgroup not
+// seen during it gets a miss, and those at EvictAfter misses are dropped.
+// The next rotation starts from the first pid. Returns the number evicted.
+func (r *Resolver) endRotation() int {
+	evicted := 0
+	r.mu.Lock()
+	for k := range r.cache {
+		if r.seen[k] {
+			continue
+		}
+		r.misses[k]++
+		if r.misses[k] >= r.opts.EvictAfter {
+			delete(r.cache, k)
+			delete(r.misses, k)
+			evicted++
+		}
+	}
+	r.mu.Unlock()
+	r.seen = make(map[uint64]bool)
+	r.cursor = ""
+	return evicted
 }
 
 // parseCgroupV2 reads /proc/<pid>/cgroup and returns the cgroup v2 path.
 // Format: "0::/path/to/cgroup"
 //
-// When reading host /proc from inside a container, the path may be relative
-// to the container's own cgroup (e.g. "/../../../burstable/pod.../container").
-// We clean the path and, if needed, prepend "/kubepods" to reconstruct the
-// absolute cgroup path.
+// When the monitor runs in its own cgroup namespace (see ProcMode), the path
+// is relative to the container's own cgroup (e.g.
+// "/../../../burstable/pod.../container"). We clean the path and, if needed,
+// prepend "/kubepods" to reconstruct the absolute cgroup path. In the host
+// cgroup namespace paths are already absolute and used as-is.
 func (r *Resolver) parseCgroupV2(path string) string {
-	f, err := os.Open(path)
-	if err != nil {
-		return ""
-	}
-	defer f.Close()
-
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		// cgroup v2 line: "0::<path>"
-		if strings.HasPrefix(line, "0::") {
-			cgPath := strings.TrimPrefix(line, "0::")
-			// Clean relative paths (e.g. "/../../../burstable/pod.../cid")
-			cgPath = filepath.Clean(cgPath)
-			// If the path lost its "kubepods" prefix due to relative traversal,
-			// try to reconstruct it by finding where "burstable" or "besteffort"
-			// or "guaranteed" appears and prepending "/kubepods".
-			if !strings.Contains(cgPath, "kubepods") {
-				for _, qos := range []string{"/burstable/", "/besteffort/", "/guaranteed/"} {
-					if idx := strings.Index(cgPath, qos); idx >= 0 {
-						cgPath = "/kubepods" + cgPath[idx:]
-						break
-					}
-				}
+	cgPath := readCgroupV2Line(path)
+	if cgPath == "" || !(r.mode.RelativeCgroups || strings.HasPrefix(cgPath, "/..")) {
+		return cgPath
+	}
+	// Clean relative paths (e.g. "/../../../burstable/pod.../cid")
+	cgPath = filepath.Clean(cgPath)
+	// If the path lost its "kubepods" prefix due to relative traversal,
+	// try to reconstruct it by finding where "burstable" or "besteffort"
+	// or "guaranteed" appears and prepending "/kubepods".
+	if !strings.Contains(cgPath, "kubepods") {
+		for _, qos := range []string{"/burstable/", "/besteffort/", "/guaranteed/"} {
+			if idx := strings.Index(cgPath, qos); idx >= 0 {
+				cgPath = "/kubepods" + cgPath[idx:]
+				break
 			}
-			return cgPath
 		}
 	}
-	return ""
+	return cgPath
 }
 
 // parsePodFromCgroupPath extracts pod/namespace/container from a
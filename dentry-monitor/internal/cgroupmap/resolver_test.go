@@ -0,0 +1,73 @@
+package cgroupmap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeHost creates a proc root with pids 1..n, each in its own pod cgroup
+// that exists under the returned cgroup root.
+func fakeHost(t *testing.T, n int) (procRoot, cgRoot string) {
+	t.Helper()
+	procRoot, cgRoot = t.TempDir(), t.TempDir()
+	for pid := 1; pid <= n; pid++ {
+		cg := fmt.Sprintf("/kubepods/burstable/pod%04d", pid)
+		if err := os.MkdirAll(filepath.Join(cgRoot, cg), 0755); err != nil {
+			t.Fatal(err)
+		}
+		dir := filepath.Join(procRoot, fmt.Sprint(pid))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "cgroup"), []byte("0::"+cg+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return procRoot, cgRoot
+}
+
+func TestRefreshRotatesThroughPids(t *testing.T) {
+	procRoot, cgRoot := fakeHost(t, 6)
+	r := NewResolver(procRoot, cgRoot, Options{MaxProcs: 2, EvictAfter: 1})
+
+	for i, want := range []int{2, 4, 6} {
+		if got := r.refresh(); got != want {
+			t.Fatalf("scan %d: %d mappings, want %d", i+1, got, want)
+		}
+	}
+	if got := r.PartialScans(); got != 3 {
+		t.Errorf("PartialScans = %d, want 3", got)
+	}
+
+	// A vanished pid is only a miss once a full rotation has passed it by.
+	if err := os.RemoveAll(filepath.Join(procRoot, "6")); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int{6, 6, 5} {
+		if got := r.refresh(); got != want {
+			t.Fatalf("scan %d after exit: %d mappings, want %d", i+1, got, want)
+		}
+	}
+}
+
+func TestRefreshUnlimitedEvicts(t *testing.T) {
+	procRoot, cgRoot := fakeHost(t, 3)
+	r := NewResolver(procRoot, cgRoot, Options{EvictAfter: 2})
+
+	if got := r.refresh(); got != 3 {
+		t.Fatalf("%d mappings, want 3", got)
+	}
+	if err := os.RemoveAll(filepath.Join(procRoot, "2")); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int{3, 2} {
+		if got := r.refresh(); got != want {
+			t.Fatalf("scan %d after exit: %d mappings, want %d", i+1, got, want)
+		}
+	}
+	if got := r.PartialScans(); got != 0 {
+		t.Errorf("PartialScans = %d, want 0", got)
+	}
+}
@@ -1,10 +1,14 @@
 package cgroupmap
 
 import (
+	"fmt"
 	"os"
 	"syscall"
 )
 
+// cgroup2SuperMagic is CGROUP2_SUPER_MAGIC from linux/magic.h.
+const cgroup2SuperMagic = 0x63677270
+
 // statIno extracts the inode number from a FileInfo on Linux.
 func statIno(info os.FileInfo) (uint64, bool) {
 	sys, ok := info.Sys().(*syscall.Stat_t)
@@ -13,3 +17,24 @@ func statIno(info os.FileInfo) (uint64, bool) {
 	}
 	return sys.Ino, true
 }
+
+// checkCgroup2Root returns an error unless path is the root of a cgroup v2
+// hierarchy. The root cgroup always has ID (inode) 1; a cgroup namespace
+// root or a bind-mounted subtree does not.
+func checkCgroup2Root(path string) error {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return err
+	}
+	if fs.Type != cgroup2SuperMagic {
+		return fmt.Errorf("%s is not a cgroup v2 mount", path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if ino, ok := statIno(info); !ok || ino != 1 {
+		return fmt.Errorf("%s is not the cgroup v2 root (inode %d)", path, ino)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package ebpf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ciliumebpf "github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+)
+
+// nativeBTF is where kernels built with CONFIG_DEBUG_INFO_BTF expose their types.
+const nativeBTF = "/sys/kernel/btf/vmlinux"
+
+// LoadOptions picks the kernel BTF used for CO-RE relocations. Native BTF is
+// preferred; without it, btfDir is searched for "<kernel release>.btf" (the
+// BTFHub layout). It returns nil options when the library's own lookup
+// should be used, plus a description of the source for logging.
+func LoadOptions(btfDir string) (*ciliumebpf.CollectionOptions, string, error) {
+	if _, err := os.Stat(nativeBTF); err == nil {
+		return nil, nativeBTF, nil
+	}
+	if btfDir == "" {
+		return nil, "default search (no " + nativeBTF + ")", nil
+	}
+
+	release, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return nil, "", fmt.Errorf("read kernel release: %w", err)
+	}
+	path := filepath.Join(btfDir, strings.TrimSpace(string(release))+".btf")
+	if _, err := os.Stat(path); err != nil {
+		return nil, "default search (no " + nativeBTF + " or " + path + ")", nil
+	}
+	spec, err := btf.LoadSpec(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("load BTF for this kernel: %w", err)
+	}
+	opts := &ciliumebpf.CollectionOptions{
+		Programs: ciliumebpf.ProgramOptions{KernelTypes: spec},
+	}
+	return opts, path, nil
+}
@@ -18,6 +18,8 @@ struct dentry_stats {
  * Path is stored as up to 8 separate name components (leaf to root).
  * Userspace reconstructs the full path by reversing the order.
  * Bit 31 of depth is set if the walk reached the filesystem root. */
+/* Keep in sync with maxDepth/nameLen/fstypeLen in tracing/consumer.go.
+ * fill_ancestors is unrolled for MAX_PATH_DEPTH levels. */
 #define MAX_PATH_DEPTH 8
 #define MAX_NAME_LEN 64
 #define MAX_FSTYPE_LEN 16
@@ -26,16 +28,22 @@ struct dentry_stats {
 struct dentry_trace_event {
     __u64 timestamp;
     __u64 cgroup_id;
-    __u32 operation; /* 0=alloc, 1=positive, 2=negative */
+    __u32 operation; /* 0=alloc, 1=positive, 2=negative, 3=reclaim */
     __u32 depth;     /* bits 0-30: component count, bit 31: reached root */
+    __u32 pid;       /* tgid of the task that triggered the event */
+    __u32 mode;      /* inode i_mode at instantiate, 0 if unknown */
     char  names[MAX_PATH_DEPTH][MAX_NAME_LEN]; /* 8 * 64 = 512 bytes */
     char  fstype[MAX_FSTYPE_LEN];              /* filesystem type name */
 };
 
-/* Tracing enabled flag (index 0 in array map) */
+/* Tracing config (index 0 in array map) */
+#define TRACE_FLAG_RECLAIM_MARKERS 0x1 /* emit a marker event per reclaim */
+#define TRACE_FLAG_CGROUP_FILTER   0x2 /* only trace cgroups in trace_cgroups */
+#define TRACE_FLAG_NEGATIVE_ONLY   0x4 /* only emit negative instantiates */
+
 struct trace_config {
     __u32 enabled; /* 0=off, 1=on */
-    __u32 _pad;
+    __u32 flags;   /* TRACE_FLAG_* */
 };
 
 /* --- Maps --- */
@@ -59,6 +67,34 @@ struct {
     __type(value, struct trace_config);
 } trace_config_map SEC(".maps");
 
+/* d_alloc completion timestamps keyed by the new dentry pointer, consumed by
+ * d_instantiate. LRU so dentries that are never instantiated age out. */
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 65536);
+    __type(key, __u64);
+    __type(value, __u64);
+} alloc_start SEC(".maps");
+
+/* log2 histogram of alloc→instantiate latency in ns.
+ * Slot i counts deltas in [2^i, 2^(i+1)); the last slot absorbs overflow. */
+#define LATENCY_SLOTS 32
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, LATENCY_SLOTS);
+    __type(key, __u32);
+    __type(value, __u64);
+} alloc_latency_hist SEC(".maps");
+
+/* Cgroup allowlist for path tracing, used with TRACE_FLAG_CGROUP_FILTER */
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, __u64);
+    __type(value, __u8);
+} trace_cgroups SEC(".maps");
+
 /* Node-level reclaim counter (single-element array) */
 struct {
     __uint(type, BPF_MAP_TYPE_ARRAY);
@@ -111,82 +147,66 @@ static __always_inline struct dentry_stats *get_or_create_stats(__u64 cgid) {
     return bpf_map_lookup_elem(&dentry_stats_map, &cgid);
 }
 
-static __always_inline bool tracing_enabled(void) {
-    __u32 key = 0;
-    struct trace_config *cfg = bpf_map_lookup_elem(&trace_config_map, &key);
-    return cfg && cfg->enabled;
+static __always_inline __u32 log2_u64(__u64 v) {
+    __u32 r, shift;
+    shift = (v > 0xFFFFFFFF) << 5; v >>= shift; r = shift;
+    shift = (v > 0xFFFF) << 4;     v >>= shift; r |= shift;
+    shift = (v > 0xFF) << 3;       v >>= shift; r |= shift;
+    shift = (v > 0xF) << 2;        v >>= shift; r |= shift;
+    shift = (v > 0x3) << 1;        v >>= shift; r |= shift;
+    r |= (v >> 1);
+    return r;
 }
 
-/* --- Kprobes --- */
-
-/*
- * d_alloc(struct dentry *parent, const struct qstr *name)
- *
- * Count dentry allocations per cgroup.
- */
-SEC("kprobe/d_alloc")
-int trace_d_alloc(struct pt_regs *ctx) {
-    __u64 cgid = bpf_get_current_cgroup_id();
+/* Observe the time since d_alloc returned this dentry, if it was seen. */
+static __always_inline void record_alloc_latency(__u64 dentry) {
+    __u64 *start = bpf_map_lookup_elem(&alloc_start, &dentry);
+    if (!start)
+        return;
+    __u64 delta = bpf_ktime_get_ns() - *start;
+    bpf_map_delete_elem(&alloc_start, &dentry);
+
+    __u32 slot = log2_u64(delta);
+    if (slot >= LATENCY_SLOTS)
+        slot = LATENCY_SLOTS - 1;
+    __u64 *count = bpf_map_lookup_elem(&alloc_latency_hist, &slot);
+    if (count)
+        __sync_fetch_and_add(count, 1);
+}
 
-    struct dentry_stats *stats = get_or_create_stats(cgid);
-    if (stats)
-        __sync_fetch_and_add(&stats->alloc, 1);
+/* True if path tracing is enabled for this cgroup. */
+static __always_inline bool cgroup_traced(__u64 cgid) {
+    __u32 key = 0;
+    struct trace_config *cfg = bpf_map_lookup_elem(&trace_config_map, &key);
+    if (!cfg || !cfg->enabled)
+        return false;
+    if (!(cfg->flags & TRACE_FLAG_CGROUP_FILTER))
+        return true;
+    return bpf_map_lookup_elem(&trace_cgroups, &cgid) != NULL;
+}
 
-    return 0;
+/* True if tracing is enabled and the given TRACE_FLAG_* is set. */
+static __always_inline bool tracing_flag(__u32 flag) {
+    __u32 key = 0;
+    struct trace_config *cfg = bpf_map_lookup_elem(&trace_config_map, &key);
+    return cfg && cfg->enabled && (cfg->flags & flag);
 }
 
 /*
- * d_alloc tracing — capture full path (up to 8 components).
- * This is a separate kprobe so the metrics path stays simple.
- *
- * d_alloc(struct dentry *parent, const struct qstr *name)
- * - names[0] = new dentry name (from qstr PARM2)
- * - names[1..7] = ancestor directory names (parent to great^6-grandparent)
+ * Fill names[1..7] by walking up the d_parent chain starting at parent.
+ * The caller sets names[0] (the leaf) and the initial depth.
  *
  * Manually unrolled to avoid verifier issues on kernel 5.10.
  * Uses goto for early exit when root is reached (d_parent == self).
  * Sets DEPTH_ROOT_FLAG when the full path to root was captured.
  */
-SEC("kprobe/d_alloc")
-int trace_d_alloc_path(struct pt_regs *ctx) {
-    if (!tracing_enabled())
-        return 0;
-
-    __u64 cgid = bpf_get_current_cgroup_id();
-    struct dentry *parent = (struct dentry *)PT_REGS_PARM1(ctx);
-    if (!parent)
-        return 0;
-
-    struct dentry_trace_event *evt = bpf_ringbuf_reserve(&trace_events,
-                                          sizeof(struct dentry_trace_event), 0);
-    if (!evt)
-        return 0;
-
-    evt->timestamp = bpf_ktime_get_ns();
-    evt->cgroup_id = cgid;
-    evt->operation = 0; /* alloc */
-    evt->depth = 0;
-
-    /* Read filesystem type from parent's superblock */
-    const char *fsname = BPF_CORE_READ(parent, d_sb, s_type, name);
-    if (fsname)
-        bpf_probe_read_kernel_str(evt->fstype, MAX_FSTYPE_LEN, (void *)fsname);
-
+static __always_inline void fill_ancestors(struct dentry_trace_event *evt,
+                                           struct dentry *parent) {
     /* Declare all dentry pointers upfront (goto-safe) */
     const unsigned char *np;
     struct dentry *d2, *d3, *d4, *d5, *d6, *d7, *d8;
     struct dentry *root_candidate = NULL;
 
-    /* names[0]: new dentry name from qstr parameter */
-    const struct qstr *qname = (const struct qstr *)PT_REGS_PARM2(ctx);
-    if (qname) {
-        np = BPF_CORE_READ(qname, name);
-        if (np) {
-            bpf_probe_read_kernel_str(evt->names[0], MAX_NAME_LEN, (void *)np);
-            evt->depth = 1;
-        }
-    }
-
     /* names[1]: parent */
     np = BPF_CORE_READ(parent, d_name.name);
     if (np) {
@@ -235,39 +255,150 @@ int trace_d_alloc_path(struct pt_regs *ctx) {
     if (!d8 || d8 == d7) { root_candidate = d7; goto check_root; }
 
     /* Truncated — more levels exist but we capped at 8 */
-    goto submit;
+    return;
 
 check_root:
     /* Only set root flag if this is a real disk filesystem (ext4/xfs/btrfs),
      * not a virtual filesystem mount root (cgroup2, overlay, proc, etc.) */
     if (root_candidate && is_real_root(root_candidate))
         evt->depth |= DEPTH_ROOT_FLAG;
+}
+
+/* --- Kprobes --- */
+
+/*
+ * d_alloc(struct dentry *parent, const struct qstr *name)
+ *
+ * Count dentry allocations per cgroup and, when tracing is enabled for the
+ * cgroup, emit the full path (up to 8 components). One program does both so
+ * d_alloc carries a single kprobe.
+ * - names[0] = new dentry name (from qstr PARM2)
+ * - names[1..7] = ancestor directory names (parent to great^6-grandparent)
+ */
+SEC("kprobe/d_alloc")
+int trace_d_alloc(struct pt_regs *ctx) {
+    __u64 cgid = bpf_get_current_cgroup_id();
+
+    struct dentry_stats *stats = get_or_create_stats(cgid);
+    if (stats)
+        __sync_fetch_and_add(&stats->alloc, 1);
+
+    if (!cgroup_traced(cgid) || tracing_flag(TRACE_FLAG_NEGATIVE_ONLY))
+        return 0;
+
+    struct dentry *parent = (struct dentry *)PT_REGS_PARM1(ctx);
+    if (!parent)
+        return 0;
+
+    struct dentry_trace_event *evt = bpf_ringbuf_reserve(&trace_events,
+                                          sizeof(struct dentry_trace_event), 0);
+    if (!evt)
+        return 0;
+
+    evt->timestamp = bpf_ktime_get_ns();
+    evt->cgroup_id = cgid;
+    evt->operation = 0; /* alloc */
+    evt->depth = 0;
+    evt->pid = bpf_get_current_pid_tgid() >> 32;
+    evt->mode = 0;
+
+    /* Read filesystem type from parent's superblock */
+    const char *fsname = BPF_CORE_READ(parent, d_sb, s_type, name);
+    if (fsname)
+        bpf_probe_read_kernel_str(evt->fstype, MAX_FSTYPE_LEN, (void *)fsname);
+
+    /* names[0]: new dentry name from qstr parameter */
+    const struct qstr *qname = (const struct qstr *)PT_REGS_PARM2(ctx);
+    if (qname) {
+        const unsigned char *np = BPF_CORE_READ(qname, name);
+        if (np) {
+            bpf_probe_read_kernel_str(evt->names[0], MAX_NAME_LEN, (void *)np);
+            evt->depth = 1;
+        }
+    }
+
+    fill_ancestors(evt, parent);
 
-submit:
     bpf_ringbuf_submit(evt, 0);
     return 0;
 }
 
+/*
+ * d_alloc return — remember when the new dentry was allocated so
+ * d_instantiate can compute the alloc→instantiate latency.
+ */
+SEC("kretprobe/d_alloc")
+int trace_d_alloc_ret(struct pt_regs *ctx) {
+    __u64 dentry = (__u64)PT_REGS_RC(ctx);
+    if (!dentry)
+        return 0;
+
+    __u64 ts = bpf_ktime_get_ns();
+    bpf_map_update_elem(&alloc_start, &dentry, &ts, BPF_ANY);
+    return 0;
+}
+
 /*
  * d_instantiate(struct dentry *dentry, struct inode *inode)
  *
- * Classify dentry as positive (inode != NULL) or negative (inode == NULL).
+ * Classify dentry as positive (inode != NULL) or negative (inode == NULL),
+ * record the latency since the matching d_alloc returned and, when tracing
+ * is enabled for the cgroup, emit the outcome with its path. One program
+ * does all three so d_instantiate carries a single kprobe, like d_alloc.
+ * - names[0] = dentry name (already linked into its parent)
+ * - names[1..7] = ancestor directory names
  */
 SEC("kprobe/d_instantiate")
 int trace_d_instantiate(struct pt_regs *ctx) {
     __u64 cgid = bpf_get_current_cgroup_id();
+    struct dentry *dentry = (struct dentry *)PT_REGS_PARM1(ctx);
     struct inode *inode = (struct inode *)PT_REGS_PARM2(ctx);
 
+    record_alloc_latency((__u64)dentry);
+
     struct dentry_stats *stats = get_or_create_stats(cgid);
-    if (!stats)
+    if (stats) {
+        if (inode)
+            __sync_fetch_and_add(&stats->positive, 1);
+        else
+            __sync_fetch_and_add(&stats->negative, 1);
+    }
+
+    if (!dentry || !cgroup_traced(cgid))
+        return 0;
+    if (inode && tracing_flag(TRACE_FLAG_NEGATIVE_ONLY))
+        return 0;
+
+    struct dentry *parent = BPF_CORE_READ(dentry, d_parent);
+    if (!parent || parent == dentry)
         return 0;
 
-    if (inode) {
-        __sync_fetch_and_add(&stats->positive, 1);
-    } else {
-        __sync_fetch_and_add(&stats->negative, 1);
+    struct dentry_trace_event *evt = bpf_ringbuf_reserve(&trace_events,
+                                          sizeof(struct dentry_trace_event), 0);
+    if (!evt)
+        return 0;
+
+    evt->timestamp = bpf_ktime_get_ns();
+    evt->cgroup_id = cgid;
+    evt->operation = inode ? 1 : 2; /* positive : negative */
+    evt->depth = 0;
+    evt->pid = bpf_get_current_pid_tgid() >> 32;
+    evt->mode = inode ? BPF_CORE_READ(inode, i_mode) : 0;
+
+    const char *fsname = BPF_CORE_READ(dentry, d_sb, s_type, name);
+    if (fsname)
+        bpf_probe_read_kernel_str(evt->fstype, MAX_FSTYPE_LEN, (void *)fsname);
+
+    /* names[0]: the dentry's own name */
+    const unsigned char *np = BPF_CORE_READ(dentry, d_name.name);
+    if (np) {
+        bpf_probe_read_kernel_str(evt->names[0], MAX_NAME_LEN, (void *)np);
+        evt->depth = 1;
     }
 
+    fill_ancestors(evt, parent);
+
+    bpf_ringbuf_submit(evt, 0);
     return 0;
 }
 
@@ -275,6 +406,8 @@ int trace_d_instantiate(struct pt_regs *ctx) {
  * shrink_dcache_sb(struct super_block *sb)
  *
  * Count reclaim events. Low frequency.
+ * With TRACE_FLAG_RECLAIM_MARKERS, also emit a marker event (no path, fstype
+ * of the superblock being shrunk) so reclaim shows up in the trace timeline.
  */
 SEC("kprobe/shrink_dcache_sb")
 int trace_shrink_dcache(struct pt_regs *ctx) {
@@ -282,5 +415,29 @@ int trace_shrink_dcache(struct pt_regs *ctx) {
     __u64 *count = bpf_map_lookup_elem(&reclaim_count, &key);
     if (count)
         __sync_fetch_and_add(count, 1);
+
+    if (!tracing_flag(TRACE_FLAG_RECLAIM_MARKERS))
+        return 0;
+
+    struct dentry_trace_event *evt = bpf_ringbuf_reserve(&trace_events,
+                                          sizeof(struct dentry_trace_event), 0);
+    if (!evt)
+        return 0;
+
+    evt->timestamp = bpf_ktime_get_ns();
+    evt->cgroup_id = bpf_get_current_cgroup_id();
+    evt->operation = 3; /* reclaim */
+    evt->depth = 0;
+    evt->pid = bpf_get_current_pid_tgid() >> 32;
+    evt->mode = 0;
+
+    struct super_block *sb = (struct super_block *)PT_REGS_PARM1(ctx);
+    const char *fsname = sb ? BPF_CORE_READ(sb, s_type, name) : NULL;
+    if (fsname)
+        bpf_probe_read_kernel_str(evt->fstype, MAX_FSTYPE_LEN, (void *)fsname);
+    else
+        evt->fstype[0] = 0;
+
+    bpf_ringbuf_submit(evt, 0);
     return 0;
 }
@@ -26,13 +26,15 @@ func (o *Objects) Close() error {
 // Programs
 
 func (o *Objects) TraceDAlloc() *ciliumebpf.Program      { return o.objs.TraceD_alloc }
-func (o *Objects) TraceDAllocPath() *ciliumebpf.Program  { return o.objs.TraceD_allocPath }
+func (o *Objects) TraceDAllocRet() *ciliumebpf.Program   { return o.objs.TraceD_allocRet }
 func (o *Objects) TraceDInstantiate() *ciliumebpf.Program { return o.objs.TraceD_instantiate }
 func (o *Objects) TraceShrinkDcache() *ciliumebpf.Program { return o.objs.TraceShrinkDcache }
 
 // Maps
 
 func (o *Objects) DentryStatsMap() *ciliumebpf.Map { return o.objs.DentryStatsMap }
+func (o *Objects) AllocLatencyHist() *ciliumebpf.Map { return o.objs.AllocLatencyHist }
 func (o *Objects) ReclaimCount() *ciliumebpf.Map   { return o.objs.ReclaimCount }
 func (o *Objects) TraceConfigMap() *ciliumebpf.Map  { return o.objs.TraceConfigMap }
 func (o *Objects) TraceEvents() *ciliumebpf.Map     { return o.objs.TraceEvents }
+func (o *Objects) TraceCgroups() *ciliumebpf.Map    { return o.objs.TraceCgroups }
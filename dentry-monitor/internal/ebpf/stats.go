@@ -0,0 +1,26 @@
+package ebpf
+
+import (
+	"io"
+
+	ciliumebpf "github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// EnableStats turns on kernel-wide BPF run-time statistics
+// (kernel.bpf_stats_enabled) until the returned Closer is closed. It costs
+// two clock reads per program run for every BPF program on the node, not
+// just ours. Requires Linux 5.8.
+func EnableStats() (io.Closer, error) {
+	return ciliumebpf.EnableStats(uint32(unix.BPF_STATS_RUN_TIME))
+}
+
+// Programs returns every loaded program keyed by its C function name.
+func (o *Objects) Programs() map[string]*ciliumebpf.Program {
+	return map[string]*ciliumebpf.Program{
+		"trace_d_alloc":       o.objs.TraceD_alloc,
+		"trace_d_alloc_ret":   o.objs.TraceD_allocRet,
+		"trace_d_instantiate": o.objs.TraceD_instantiate,
+		"trace_shrink_dcache": o.objs.TraceShrinkDcache,
+	}
+}
@@ -0,0 +1,28 @@
+// Package jitter randomizes periodic intervals so that monitors running on
+// every node of a fleet do not poll in lockstep.
+package jitter
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Duration returns d adjusted by a uniformly random amount in [-frac*d, +frac*d].
+// The adjustment is symmetric, so the mean interval stays d.
+// A frac <= 0 returns d unchanged.
+func Duration(d time.Duration, frac float64) time.Duration {
+	if frac <= 0 || d <= 0 {
+		return d
+	}
+	delta := (rand.Float64()*2 - 1) * frac * float64(d)
+	return d + time.Duration(delta)
+}
+
+// Offset returns a random initial delay in (0, d] used to desynchronize the
+// phase of the first tick. A frac <= 0 disables the offset and returns d.
+func Offset(d time.Duration, frac float64) time.Duration {
+	if frac <= 0 || d <= 0 {
+		return d
+	}
+	return time.Duration(rand.Int64N(int64(d))) + 1
+}
@@ -0,0 +1,45 @@
+package jitter
+
+import (
+	"testing"
+	"time"
+)
+
+const samples = 10000
+
+func TestDuration(t *testing.T) {
+	const d, frac = time.Second, 0.2
+	lo, hi := d-time.Duration(frac*float64(d)), d+time.Duration(frac*float64(d))
+	var sum time.Duration
+	for i := 0; i < samples; i++ {
+		got := Duration(d, frac)
+		if got < lo || got > hi {
+			t.Fatalf("Duration(%s, %v) = %s, want within [%s, %s]", d, frac, got, lo, hi)
+		}
+		sum += got
+	}
+	// The standard error of the mean is about 1.2ms here; 10ms is ~8 sigma.
+	if mean := sum / samples; mean < d-10*time.Millisecond || mean > d+10*time.Millisecond {
+		t.Errorf("mean of Duration(%s, %v) = %s, want about %s", d, frac, mean, d)
+	}
+}
+
+func TestDurationDisabled(t *testing.T) {
+	for _, frac := range []float64{0, -1} {
+		if got := Duration(time.Second, frac); got != time.Second {
+			t.Errorf("Duration(1s, %v) = %s, want 1s", frac, got)
+		}
+	}
+}
+
+func TestOffset(t *testing.T) {
+	const d = 10 * time.Millisecond
+	for i := 0; i < samples; i++ {
+		if got := Offset(d, 0.1); got <= 0 || got > d {
+			t.Fatalf("Offset(%s, 0.1) = %s, want within (0, %s]", d, got, d)
+		}
+	}
+	if got := Offset(d, 0); got != d {
+		t.Errorf("Offset(%s, 0) = %s, want %s", d, got, d)
+	}
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"log"
+	"sort"
+
+	"github.com/cilium/ebpf"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ProgramStatsCollector exports the kernel's per-program run statistics.
+// The kernel only counts while BPF stats are enabled; until then both
+// series read 0.
+type ProgramStatsCollector struct {
+	programs map[string]*ebpf.Program
+	names    []string // sorted keys of programs
+
+	countDesc *prometheus.Desc
+	timeDesc  *prometheus.Desc
+}
+
+// NewProgramStatsCollector creates a collector for the given programs, keyed
+// by the name used in the program label. Prefix and labels follow Options.
+func NewProgramStatsCollector(programs map[string]*ebpf.Program, prefix string, labels prometheus.Labels) *ProgramStatsCollector {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	names := make([]string, 0, len(programs))
+	for name := range programs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return &ProgramStatsCollector{
+		programs: programs,
+		names:    names,
+		countDesc: prometheus.NewDesc(
+			prefix+"_bpf_program_run_count",
+			"Times each BPF program ran since BPF stats were enabled",
+			[]string{"program"}, labels,
+		),
+		timeDesc: prometheus.NewDesc(
+			prefix+"_bpf_program_run_time_seconds",
+			"Total time spent running each BPF program since BPF stats were enabled",
+			[]string{"program"}, labels,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *ProgramStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.countDesc
+	ch <- c.timeDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *ProgramStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, name := range c.names {
+		prog := c.programs[name]
+		if prog == nil {
+			continue
+		}
+		stats, err := prog.Stats()
+		if err != nil {
+			log.Printf("collector: stats for program %s: %v", name, err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.countDesc, prometheus.CounterValue,
+			float64(stats.RunCount), name)
+		ch <- prometheus.MustNewConstMetric(c.timeDesc, prometheus.CounterValue,
+			stats.Runtime.Seconds(), name)
+	}
+}
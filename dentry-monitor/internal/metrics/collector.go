@@ -2,33 +2,92 @@ package metrics
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cilium/ebpf"
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/rophy/mem-psi-test/dentry-monitor/internal/cgroupmap"
+	"github.com/rophy/mem-psi-test/dentry-monitor/internal/jitter"
 )
 
 // DentryStats matches the eBPF struct dentry_stats.
 type DentryStats struct {
-	Alloc    uint64
-	Positive uint64
-	Negative uint64
+	Alloc    uint64 `json:"alloc"`
+	Positive uint64 `json:"positive"`
+	Negative uint64 `json:"negative"`
 }
 
+// add accumulates o into s.
+func (s *DentryStats) add(o DentryStats) {
+	s.Alloc += o.Alloc
+	s.Positive += o.Positive
+	s.Negative += o.Negative
+}
+
+// latencySlots matches LATENCY_SLOTS in the eBPF program: slot i counts
+// alloc→instantiate deltas in [2^i, 2^(i+1)) ns, the last slot is overflow.
+const latencySlots = 32
+
+// Options tunes the collector.
+type Options struct {
+	// NodeStateTimeout bounds the live read of /proc/sys/fs/dentry-state
+	// during a scrape (0 = no limit).
+	NodeStateTimeout time.Duration
+
+	// DisableNodeState skips reading /proc/sys/fs/dentry-state and drops
+	// dentry_count and dentry_count_stale entirely.
+	DisableNodeState bool
+
+	// DropUnresolved skips per-container series for cgroups the resolver
+	// can't map to a pod, instead of labelling them "cgroup-<id>".
+	DropUnresolved bool
+
+	// Prefix replaces the "dentry" prefix of every metric name
+	// (default "dentry").
+	Prefix string
+
+	// ConstLabels are added to every metric, e.g. node and cluster.
+	ConstLabels prometheus.Labels
+
+	// NegativeRatioThreshold flags containers in Summary whose
+	// negative/(positive+negative) ratio exceeds it (0 = never flag).
+	NegativeRatioThreshold float64
+}
+
+// ContainerSummary is one container's row in Summary.
+type ContainerSummary struct {
+	CgroupID  uint64 `json:"cgroup_id"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	DentryStats
+	NegativeRatio float64 `json:"negative_ratio"` // negative / (positive + negative)
+	AllocRate     float64 `json:"alloc_rate"`     // allocs/s between the last two polls
+	OverThreshold bool    `json:"over_threshold"`
+}
+
+// DefaultPrefix is the metric name prefix used when Options.Prefix is empty.
+const DefaultPrefix = "dentry"
+
 // Collector polls BPF maps and exposes Prometheus metrics.
 type Collector struct {
 	statsMap    *ebpf.Map
 	reclaimMap  *ebpf.Map
+	latencyMap  *ebpf.Map
 	resolver    *cgroupmap.Resolver
 	procRoot    string
+	nodeState   *nodeStateReader
+	opts        Options
 
 	// Prometheus descriptors
 	allocDesc   *prometheus.Desc
@@ -36,43 +95,125 @@ type Collector struct {
 	negDesc     *prometheus.Desc
 	reclaimDesc *prometheus.Desc
 	nodeDesc    *prometheus.Desc
+	partialDesc *prometheus.Desc
+	latencyDesc *prometheus.Desc
+	staleDesc   *prometheus.Desc
+	overlapDesc *prometheus.Desc
+	prunedDesc  *prometheus.Desc
+	cacheDesc   *prometheus.Desc
+	activeDesc  *prometheus.Desc
+	opsDesc     *prometheus.Desc
+
+	pruned atomic.Uint64 // stats entries deleted for dead cgroups
+
+	// Scrape self-instrumentation. Prometheus may overlap scrapes; Collect
+	// serializes them on collectMu and counts the ones that had to wait.
+	collectMu       sync.Mutex
+	collectInflight atomic.Int32
+	collectOverlaps atomic.Uint64
+	collectDuration prometheus.Histogram
+
+	// pollMu serializes Poll with itself and with the prune and reset steps
+	// that delete map entries, so a snapshot never lands over a newer one
+	// and an entry is never counted both live and retired.
+	pollMu sync.Mutex
 
-	mu    sync.Mutex
-	stats map[uint64]DentryStats // snapshot from last poll
+	mu       sync.Mutex
+	stats    map[uint64]DentryStats // snapshot from last poll
+	totals   DentryStats            // node-wide sum of stats plus retired
+	retired  DentryStats            // counts of entries removed by PruneDead
+	rates    map[uint64]float64     // allocs/s between the last two polls
+	active   map[uint64]time.Time   // last poll at which a cgroup's counters moved
+	lastPoll time.Time
 }
 
 // NewCollector creates a metrics collector.
-func NewCollector(statsMap, reclaimMap *ebpf.Map, resolver *cgroupmap.Resolver, procRoot string) *Collector {
+func NewCollector(statsMap, reclaimMap, latencyMap *ebpf.Map, resolver *cgroupmap.Resolver, procRoot string, opts Options) *Collector {
+	prefix := opts.Prefix
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	labels := opts.ConstLabels
 	return &Collector{
 		statsMap:   statsMap,
 		reclaimMap: reclaimMap,
+		latencyMap: latencyMap,
 		resolver:   resolver,
 		procRoot:   procRoot,
+		nodeState:  &nodeStateReader{procRoot: procRoot, timeout: opts.NodeStateTimeout},
+		opts:       opts,
 		stats:      make(map[uint64]DentryStats),
+		active:     make(map[uint64]time.Time),
 		allocDesc: prometheus.NewDesc(
-			"dentry_alloc_total",
+			prefix+"_alloc_total",
 			"Total dentry allocations per container",
-			[]string{"pod", "container"}, nil,
+			[]string{"pod", "container"}, labels,
 		),
 		posDesc: prometheus.NewDesc(
-			"dentry_positive_total",
+			prefix+"_positive_total",
 			"Total positive dentry instantiations per container",
-			[]string{"pod", "container"}, nil,
+			[]string{"pod", "container"}, labels,
 		),
 		negDesc: prometheus.NewDesc(
-			"dentry_negative_total",
+			prefix+"_negative_total",
 			"Total negative dentry instantiations per container",
-			[]string{"pod", "container"}, nil,
+			[]string{"pod", "container"}, labels,
 		),
 		reclaimDesc: prometheus.NewDesc(
-			"dentry_reclaim_total",
+			prefix+"_reclaim_total",
 			"Total dentry reclaim events (shrink_dcache_sb calls)",
-			nil, nil,
+			nil, labels,
 		),
 		nodeDesc: prometheus.NewDesc(
-			"dentry_count",
+			prefix+"_count",
 			"Node-level dentry counts from /proc/sys/fs/dentry-state",
-			[]string{"type"}, nil,
+			[]string{"type"}, labels,
+		),
+		partialDesc: prometheus.NewDesc(
+			prefix+"_resolver_partial_scans_total",
+			"Resolver refreshes cut short by the max-procs or scan-budget limit",
+			nil, labels,
+		),
+		latencyDesc: prometheus.NewDesc(
+			prefix+"_alloc_to_instantiate_seconds",
+			"Time from d_alloc returning to d_instantiate of the same dentry (log2 buckets, sum approximated)",
+			nil, labels,
+		),
+		overlapDesc: prometheus.NewDesc(
+			prefix+"_collect_overlapping_total",
+			"Scrapes that started while another Collect was still running",
+			nil, labels,
+		),
+		activeDesc: prometheus.NewDesc(
+			prefix+"_cgroup_last_activity_timestamp",
+			"Unix time of the last poll at which a container's dentry counters changed (first seen counts as a change)",
+			[]string{"pod", "container"}, labels,
+		),
+		opsDesc: prometheus.NewDesc(
+			prefix+"_events_by_operation_total",
+			"Dentry operations on the node by operation, summed over the per-cgroup stats map",
+			[]string{"operation"}, labels,
+		),
+		cacheDesc: prometheus.NewDesc(
+			prefix+"_resolver_cache_entries",
+			"Cgroup to pod mappings held in the resolver cache",
+			nil, labels,
+		),
+		prunedDesc: prometheus.NewDesc(
+			prefix+"_stats_pruned_total",
+			"Per-cgroup stats map entries deleted because the cgroup no longer exists",
+			nil, labels,
+		),
+		collectDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        prefix + "_collect_duration_seconds",
+			Help:        "Time spent in Collect, excluding time waiting on an overlapping scrape",
+			Buckets:     prometheus.ExponentialBuckets(0.0005, 2, 14), // 0.5ms .. ~4s
+			ConstLabels: labels,
+		}),
+		staleDesc: prometheus.NewDesc(
+			prefix+"_count_stale",
+			"1 if the last read of /proc/sys/fs/dentry-state timed out and dentry_count was omitted",
+			nil, labels,
 		),
 	}
 }
@@ -82,24 +223,67 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.allocDesc
 	ch <- c.posDesc
 	ch <- c.negDesc
+	ch <- c.activeDesc
+	ch <- c.opsDesc
 	ch <- c.reclaimDesc
-	ch <- c.nodeDesc
+	ch <- c.partialDesc
+	ch <- c.latencyDesc
+	ch <- c.overlapDesc
+	ch <- c.prunedDesc
+	ch <- c.cacheDesc
+	if !c.opts.DisableNodeState {
+		ch <- c.nodeDesc
+		ch <- c.staleDesc
+	}
+	c.collectDuration.Describe(ch)
 }
 
 // Collect implements prometheus.Collector.
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	if c.collectInflight.Add(1) > 1 {
+		c.collectOverlaps.Add(1)
+	}
+	defer c.collectInflight.Add(-1)
+
+	c.collectMu.Lock()
+	defer c.collectMu.Unlock()
+	start := time.Now()
+
+	c.collect(ch)
+
+	c.collectDuration.Observe(time.Since(start).Seconds())
+	c.collectDuration.Collect(ch)
+	ch <- prometheus.MustNewConstMetric(c.overlapDesc, prometheus.CounterValue,
+		float64(c.collectOverlaps.Load()))
+}
+
+func (c *Collector) collect(ch chan<- prometheus.Metric) {
 	c.mu.Lock()
-	snapshot := c.stats
+	snapshot, active, totals := c.stats, c.active, c.totals
 	c.mu.Unlock()
 
+	ch <- prometheus.MustNewConstMetric(c.opsDesc, prometheus.CounterValue,
+		float64(totals.Alloc), "alloc")
+	ch <- prometheus.MustNewConstMetric(c.opsDesc, prometheus.CounterValue,
+		float64(totals.Positive), "positive")
+	ch <- prometheus.MustNewConstMetric(c.opsDesc, prometheus.CounterValue,
+		float64(totals.Negative), "negative")
+
 	for cgID, s := range snapshot {
-		pod, ctr := c.resolveLabels(cgID)
+		pod, ctr, ok := c.resolveLabels(cgID)
+		if !ok && c.opts.DropUnresolved {
+			continue
+		}
 		ch <- prometheus.MustNewConstMetric(c.allocDesc, prometheus.CounterValue,
 			float64(s.Alloc), pod, ctr)
 		ch <- prometheus.MustNewConstMetric(c.posDesc, prometheus.CounterValue,
 			float64(s.Positive), pod, ctr)
 		ch <- prometheus.MustNewConstMetric(c.negDesc, prometheus.CounterValue,
 			float64(s.Negative), pod, ctr)
+		if t, ok := active[cgID]; ok {
+			ch <- prometheus.MustNewConstMetric(c.activeDesc, prometheus.GaugeValue,
+				float64(t.Unix()), pod, ctr)
+		}
 	}
 
 	// Reclaim counter
@@ -110,59 +294,356 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 			float64(reclaimVal))
 	}
 
-	// Node-level dentry state
-	total, unused, negative := readDentryState(c.procRoot)
-	if total >= 0 {
-		ch <- prometheus.MustNewConstMetric(c.nodeDesc, prometheus.GaugeValue,
-			float64(total), "total")
-		ch <- prometheus.MustNewConstMetric(c.nodeDesc, prometheus.GaugeValue,
-			float64(unused), "unused")
-		ch <- prometheus.MustNewConstMetric(c.nodeDesc, prometheus.GaugeValue,
-			float64(negative), "negative")
+	if !c.opts.DisableNodeState {
+		c.collectNodeState(ch)
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.partialDesc, prometheus.CounterValue,
+		float64(c.resolver.PartialScans()))
+	ch <- prometheus.MustNewConstMetric(c.prunedDesc, prometheus.CounterValue,
+		float64(c.pruned.Load()))
+	ch <- prometheus.MustNewConstMetric(c.cacheDesc, prometheus.GaugeValue,
+		float64(c.resolver.Len()))
+
+	if m, err := c.latencyHistogram(); err == nil {
+		ch <- m
+	} else {
+		log.Printf("collector: latency histogram: %v", err)
+	}
+}
+
+// latencyHistogram converts the eBPF log2 histogram into a Prometheus
+// histogram. The kernel only keeps counts, so the sum is estimated from
+// each slot's midpoint (1.5 * 2^i ns).
+func (c *Collector) latencyHistogram() (prometheus.Metric, error) {
+	buckets := make(map[float64]uint64, latencySlots-1)
+	var count uint64
+	var sum float64
+	for slot := uint32(0); slot < latencySlots; slot++ {
+		var n uint64
+		if err := c.latencyMap.Lookup(&slot, &n); err != nil {
+			return nil, err
+		}
+		count += n
+		sum += float64(n) * 1.5 * math.Ldexp(1, int(slot)) / 1e9
+		if slot < latencySlots-1 {
+			// Upper bound of slot i is 2^(i+1) ns; the overflow slot
+			// only contributes to +Inf (the total count).
+			buckets[math.Ldexp(1, int(slot)+1)/1e9] = count
+		}
 	}
+	return prometheus.NewConstHistogram(c.latencyDesc, count, sum, buckets)
 }
 
 // Poll reads BPF maps and updates the internal snapshot.
+// Both BPF_MAP_TYPE_HASH and BPF_MAP_TYPE_PERCPU_HASH stats maps are
+// supported; per-CPU values are summed into one DentryStats per cgroup.
 func (c *Collector) Poll() {
+	c.pollMu.Lock()
+	defer c.pollMu.Unlock()
+	c.poll()
+}
+
+// poll does the work of Poll; the caller holds pollMu.
+func (c *Collector) poll() {
 	newStats := make(map[uint64]DentryStats)
 
 	var key uint64
-	var val DentryStats
 	iter := c.statsMap.Iterate()
-	for iter.Next(&key, &val) {
-		newStats[key] = val
+	if c.statsMap.Type() == ebpf.PerCPUHash {
+		var perCPU []DentryStats
+		for iter.Next(&key, &perCPU) {
+			var sum DentryStats
+			for _, v := range perCPU {
+				sum.add(v)
+			}
+			newStats[key] = sum
+		}
+	} else {
+		var val DentryStats
+		for iter.Next(&key, &val) {
+			newStats[key] = val
+		}
 	}
 	if err := iter.Err(); err != nil {
 		log.Printf("collector: map iterate error: %v", err)
 	}
 
+	now := time.Now()
 	c.mu.Lock()
+	totals := c.retired
+	for _, s := range newStats {
+		totals.add(s)
+	}
+	rates := make(map[uint64]float64, len(newStats))
+	if elapsed := now.Sub(c.lastPoll).Seconds(); !c.lastPoll.IsZero() && elapsed > 0 {
+		for cgID, s := range newStats {
+			if prev, ok := c.stats[cgID]; ok && s.Alloc >= prev.Alloc {
+				rates[cgID] = float64(s.Alloc-prev.Alloc) / elapsed
+			}
+		}
+	}
+	active := make(map[uint64]time.Time, len(newStats))
+	for cgID, s := range newStats {
+		if prev, ok := c.stats[cgID]; ok && prev == s {
+			active[cgID] = c.active[cgID]
+		} else {
+			active[cgID] = now
+		}
+	}
 	c.stats = newStats
+	c.totals = totals
+	c.rates = rates
+	c.active = active
+	c.lastPoll = now
 	c.mu.Unlock()
 }
 
-// Start begins periodic polling. Call via goroutine.
-func (c *Collector) Start(interval time.Duration, stopCh <-chan struct{}) {
+// Summary returns per-container counts from the last poll, sorted by
+// negative count descending and limited to the top entries (0 = all).
+func (c *Collector) Summary(top int) []ContainerSummary {
+	c.mu.Lock()
+	snapshot, rates := c.stats, c.rates
+	c.mu.Unlock()
+
+	out := make([]ContainerSummary, 0, len(snapshot))
+	for cgID, s := range snapshot {
+		pod, ctr, ok := c.resolveLabels(cgID)
+		if !ok && c.opts.DropUnresolved {
+			continue
+		}
+		row := ContainerSummary{
+			CgroupID:    cgID,
+			Pod:         pod,
+			Container:   ctr,
+			DentryStats: s,
+			AllocRate:   rates[cgID],
+		}
+		if inst := s.Positive + s.Negative; inst > 0 {
+			row.NegativeRatio = float64(s.Negative) / float64(inst)
+		}
+		row.OverThreshold = c.opts.NegativeRatioThreshold > 0 &&
+			row.NegativeRatio > c.opts.NegativeRatioThreshold
+		out = append(out, row)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Negative != out[j].Negative {
+			return out[i].Negative > out[j].Negative
+		}
+		return out[i].CgroupID < out[j].CgroupID
+	})
+	if top > 0 && top < len(out) {
+		out = out[:top]
+	}
+	return out
+}
+
+// ResetReclaim zeroes the reclaim counter in the BPF map and returns the
+// value it held. This is destructive: dentry_reclaim_total restarts from 0.
+func (c *Collector) ResetReclaim() (uint64, error) {
+	var key uint32
+	var prev, zero uint64
+	if err := c.reclaimMap.Lookup(&key, &prev); err != nil {
+		return 0, fmt.Errorf("lookup reclaim count: %w", err)
+	}
+	if err := c.reclaimMap.Update(&key, &zero, ebpf.UpdateExist); err != nil {
+		return 0, fmt.Errorf("reset reclaim count: %w", err)
+	}
+	log.Printf("collector: reclaim counter reset (was %d)", prev)
+	return prev, nil
+}
+
+// ResetStats deletes every per-cgroup entry from the stats map and returns
+// the values they held. The kernel recreates entries from zero on the next
+// event. This is destructive: per-container counters restart from 0.
+func (c *Collector) ResetStats() (map[uint64]DentryStats, error) {
+	c.pollMu.Lock()
+	defer c.pollMu.Unlock()
+
+	c.poll()
+	c.mu.Lock()
+	prev := c.stats
+	c.mu.Unlock()
+
+	for cgID := range prev {
+		key := cgID
+		if err := c.statsMap.Delete(&key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return nil, fmt.Errorf("delete stats for cgroup %d: %w", cgID, err)
+		}
+	}
+	c.mu.Lock()
+	c.retired = DentryStats{}
+	c.mu.Unlock()
+	c.poll()
+	log.Printf("collector: per-cgroup stats reset (%d entries)", len(prev))
+	return prev, nil
+}
+
+// PruneDead deletes stats map entries whose cgroup no longer exists under
+// the resolver's cgroup root and returns how many were removed. Without it
+// the map keeps one entry per cgroup ever seen until it fills up and new
+// cgroups stop being counted.
+func (c *Collector) PruneDead() (int, error) {
+	c.pollMu.Lock()
+	defer c.pollMu.Unlock()
+
+	// Snapshot keys before walking cgroupfs: a cgroup created after this
+	// point has no key in the snapshot, so it can't be pruned by mistake.
+	c.poll()
+	c.mu.Lock()
+	snapshot := c.stats
+	c.mu.Unlock()
+
+	live, err := c.resolver.LiveCgroupIDs()
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for cgID := range snapshot {
+		if live[cgID] {
+			continue
+		}
+		key := cgID
+		if err := c.statsMap.Delete(&key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return pruned, fmt.Errorf("delete stats for cgroup %d: %w", cgID, err)
+		}
+		c.mu.Lock()
+		c.retired.add(snapshot[cgID])
+		c.mu.Unlock()
+		pruned++
+	}
+	c.pruned.Add(uint64(pruned))
+	if pruned > 0 {
+		c.poll()
+	}
+	return pruned, nil
+}
+
+// StartPruning runs PruneDead once, then every interval until stopCh is
+// closed (interval <= 0 prunes only once). Call via goroutine.
+func (c *Collector) StartPruning(interval time.Duration, stopCh <-chan struct{}) {
+	if n, err := c.PruneDead(); err != nil {
+		log.Printf("collector: prune dead cgroups: %v", err)
+	} else {
+		log.Printf("collector: startup prune removed %d stats entries for dead cgroups", n)
+	}
+	if interval <= 0 {
+		return
+	}
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n, err := c.PruneDead()
+			if err != nil {
+				log.Printf("collector: prune dead cgroups: %v", err)
+			} else if n > 0 {
+				log.Printf("collector: pruned %d stats entries for dead cgroups", n)
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// Start begins periodic polling. Call via goroutine.
+// jitterFrac randomizes each interval by up to ±jitterFrac and offsets the
+// first tick so that collectors across nodes don't poll in lockstep.
+func (c *Collector) Start(interval time.Duration, jitterFrac float64, stopCh <-chan struct{}) {
+	timer := time.NewTimer(jitter.Offset(interval, jitterFrac))
+	defer timer.Stop()
 
 	c.Poll() // initial poll
 	for {
 		select {
-		case <-ticker.C:
+		case <-timer.C:
 			c.Poll()
+			timer.Reset(jitter.Duration(interval, jitterFrac))
 		case <-stopCh:
 			return
 		}
 	}
 }
 
-func (c *Collector) resolveLabels(cgID uint64) (pod, container string) {
+// resolveLabels returns the pod/container labels for a cgroup. Unresolved
+// cgroups get a synthetic "cgroup-<id>" pod label and ok=false.
+func (c *Collector) resolveLabels(cgID uint64) (pod, container string, ok bool) {
 	info := c.resolver.Resolve(cgID)
 	if info != nil {
-		return info.Pod, info.Container
+		return info.Pod, info.Container, true
+	}
+	return fmt.Sprintf("cgroup-%d", cgID), "", false
+}
+
+// dentryState holds the fields of /proc/sys/fs/dentry-state we export.
+type dentryState struct {
+	total, unused, negative int64
+}
+
+// collectNodeState emits node-level dentry state (live read, guarded by a
+// timeout).
+func (c *Collector) collectNodeState(ch chan<- prometheus.Metric) {
+	state, ok := c.nodeState.read()
+	if ok && state.total >= 0 {
+		ch <- prometheus.MustNewConstMetric(c.nodeDesc, prometheus.GaugeValue,
+			float64(state.total), "total")
+		ch <- prometheus.MustNewConstMetric(c.nodeDesc, prometheus.GaugeValue,
+			float64(state.unused), "unused")
+		ch <- prometheus.MustNewConstMetric(c.nodeDesc, prometheus.GaugeValue,
+			float64(state.negative), "negative")
+	}
+	var stale float64
+	if !ok {
+		stale = 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.staleDesc, prometheus.GaugeValue, stale)
+}
+
+// nodeStateReader reads dentry-state with a deadline. A read that overruns
+// keeps running in the background (file reads can't be interrupted) and no
+// new read is started until it returns, so a hung /proc costs at most one
+// goroutine instead of one per scrape.
+type nodeStateReader struct {
+	procRoot string
+	timeout  time.Duration
+
+	mu       sync.Mutex
+	inflight chan dentryState // non-nil while a read is running
+}
+
+// read returns the current dentry state, or ok=false if the read did not
+// complete within the timeout.
+func (r *nodeStateReader) read() (state dentryState, ok bool) {
+	if r.timeout <= 0 {
+		total, unused, negative := readDentryState(r.procRoot)
+		return dentryState{total, unused, negative}, true
+	}
+
+	r.mu.Lock()
+	ch := r.inflight
+	if ch == nil {
+		ch = make(chan dentryState, 1)
+		r.inflight = ch
+		go func() {
+			total, unused, negative := readDentryState(r.procRoot)
+			ch <- dentryState{total, unused, negative}
+		}()
+	}
+	r.mu.Unlock()
+
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+	select {
+	case state = <-ch:
+		r.mu.Lock()
+		r.inflight = nil
+		r.mu.Unlock()
+		return state, true
+	case <-timer.C:
+		log.Printf("collector: reading %s/sys/fs/dentry-state timed out after %s", r.procRoot, r.timeout)
+		return dentryState{}, false
 	}
-	return fmt.Sprintf("cgroup-%d", cgID), ""
 }
 
 // readDentryState parses /proc/sys/fs/dentry-state.
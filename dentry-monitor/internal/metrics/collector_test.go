@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/rophy/mem-psi-test/dentry-monitor/internal/cgroupmap"
+)
+
+// newTestCollector builds a Collector on real (unattached) BPF maps shaped
+// like the ones in dentry.c. It skips the test when the kernel or the
+// process can't create BPF maps.
+func newTestCollector(t *testing.T, cgRoot string) (*Collector, *ebpf.Map) {
+	t.Helper()
+	newMap := func(spec *ebpf.MapSpec) *ebpf.Map {
+		m, err := ebpf.NewMap(spec)
+		if err != nil {
+			t.Skipf("cannot create BPF map: %v", err)
+		}
+		t.Cleanup(func() { m.Close() })
+		return m
+	}
+	stats := newMap(&ebpf.MapSpec{Type: ebpf.Hash, KeySize: 8, ValueSize: 24, MaxEntries: 64})
+	reclaim := newMap(&ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 1})
+	latency := newMap(&ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: latencySlots})
+	resolver := cgroupmap.NewResolver(t.TempDir(), cgRoot, cgroupmap.Options{})
+	return NewCollector(stats, reclaim, latency, resolver, t.TempDir(), Options{DisableNodeState: true}), stats
+}
+
+func putStats(t *testing.T, m *ebpf.Map, cgID uint64, s DentryStats) {
+	t.Helper()
+	if err := m.Put(&cgID, &s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// collected returns desc's metrics from c, keyed by their label values
+// joined with ",".
+func collected(t *testing.T, c prometheus.Collector, desc *prometheus.Desc) map[string]*dto.Metric {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 256)
+	c.Collect(ch)
+	close(ch)
+	out := map[string]*dto.Metric{}
+	for m := range ch {
+		if m.Desc() != desc {
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		var key string
+		for i, l := range pb.GetLabel() {
+			if i > 0 {
+				key += ","
+			}
+			key += l.GetValue()
+		}
+		out[key] = &pb
+	}
+	return out
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	c, _ := newTestCollector(t, t.TempDir())
+	counts := map[uint32]uint64{0: 2, 3: 5, latencySlots - 1: 1} // last slot is overflow
+	for slot, n := range counts {
+		if err := c.latencyMap.Put(&slot, &n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := collected(t, c, c.latencyDesc)[""].GetHistogram()
+	if h == nil {
+		t.Fatal("no latency histogram collected")
+	}
+	if got := h.GetSampleCount(); got != 8 {
+		t.Errorf("count = %d, want 8", got)
+	}
+	// Each slot contributes its midpoint, 1.5 * 2^i ns.
+	wantSum := (2*1.5 + 5*1.5*8 + 1*1.5*math.Ldexp(1, latencySlots-1)) / 1e9
+	if got := h.GetSampleSum(); math.Abs(got-wantSum) > 1e-12 {
+		t.Errorf("sum = %g, want %g", got, wantSum)
+	}
+	if got := len(h.GetBucket()); got != latencySlots-1 {
+		t.Errorf("%d buckets, want %d", got, latencySlots-1)
+	}
+	// Buckets are cumulative; slot i's upper bound is 2^(i+1) ns.
+	want := map[float64]uint64{2e-9: 2, 4e-9: 2, 8e-9: 2, 16e-9: 7, 32e-9: 7, math.Ldexp(1, latencySlots-1) / 1e9: 7}
+	for _, b := range h.GetBucket() {
+		if w, ok := want[b.GetUpperBound()]; ok && b.GetCumulativeCount() != w {
+			t.Errorf("bucket le=%g: %d, want %d", b.GetUpperBound(), b.GetCumulativeCount(), w)
+		}
+	}
+}
+
+func TestLastActivity(t *testing.T) {
+	c, stats := newTestCollector(t, t.TempDir())
+	putStats(t, stats, 1, DentryStats{Alloc: 1})
+	putStats(t, stats, 2, DentryStats{Alloc: 1})
+	c.Poll()
+	first := c.active
+
+	time.Sleep(1100 * time.Millisecond) // the gauge has second resolution
+	putStats(t, stats, 2, DentryStats{Alloc: 2})
+	c.Poll()
+
+	if !c.active[1].Equal(first[1]) {
+		t.Errorf("idle cgroup moved from %v to %v", first[1], c.active[1])
+	}
+	if !c.active[2].After(first[2]) {
+		t.Errorf("active cgroup stayed at %v", c.active[2])
+	}
+	got := collected(t, c, c.activeDesc)
+	// Labels come sorted by name: container (empty), then pod.
+	for key, cg := range map[string]uint64{",cgroup-1": 1, ",cgroup-2": 2} {
+		if v := got[key].GetGauge().GetValue(); v != float64(c.active[cg].Unix()) {
+			t.Errorf("%s last activity = %v, want %d", key, v, c.active[cg].Unix())
+		}
+	}
+}
+
+func TestOperationTotals(t *testing.T) {
+	c, stats := newTestCollector(t, t.TempDir())
+	putStats(t, stats, 1, DentryStats{Alloc: 10, Positive: 3, Negative: 5})
+	putStats(t, stats, 2, DentryStats{Alloc: 1, Positive: 1})
+	c.Poll()
+
+	want := map[string]float64{"alloc": 11, "positive": 4, "negative": 5}
+	check := func(when string) {
+		t.Helper()
+		got := collected(t, c, c.opsDesc)
+		for op, w := range want {
+			if v := got[op].GetCounter().GetValue(); v != w {
+				t.Errorf("%s: %s total = %v, want %v", when, op, v, w)
+			}
+		}
+	}
+	check("after poll")
+
+	// Pruned entries stay in the totals (needs the real cgroup v2 root;
+	// cgroup IDs this large never exist).
+	pruner, pstats := newTestCollector(t, "/sys/fs/cgroup")
+	putStats(t, pstats, 1<<62, DentryStats{Alloc: 10, Positive: 3, Negative: 5})
+	putStats(t, pstats, 1<<62+1, DentryStats{Alloc: 1, Positive: 1})
+	if n, err := pruner.PruneDead(); err != nil {
+		t.Logf("skipping prune check: %v", err)
+	} else {
+		if n != 2 {
+			t.Errorf("pruned %d entries, want 2", n)
+		}
+		c = pruner
+		check("after prune")
+	}
+
+	if _, err := c.ResetStats(); err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]float64{"alloc": 0, "positive": 0, "negative": 0}
+	check("after reset")
+}
+
+func TestPruneDeadRefusesNonCgroup2Root(t *testing.T) {
+	dir := t.TempDir()
+	c, stats := newTestCollector(t, dir)
+	putStats(t, stats, 1, DentryStats{Alloc: 1})
+	if _, err := c.PruneDead(); err == nil {
+		t.Fatalf("PruneDead on %s succeeded", dir)
+	}
+	var s DentryStats
+	key := uint64(1)
+	if err := stats.Lookup(&key, &s); err != nil {
+		t.Errorf("entry deleted despite the error: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package tracing
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cilium/ebpf"
+
+	"github.com/rophy/mem-psi-test/dentry-monitor/internal/cgroupmap"
+)
+
+// maxTracedCgroups matches max_entries of the trace_cgroups BPF map.
+const maxTracedCgroups = 1024
+
+// cgroupFilter maintains the set of cgroups selected by TraceConfig.CgroupIDs
+// and TraceConfig.Pods. The set is mirrored into the BPF trace_cgroups map so
+// the kernel skips other cgroups, and kept in userspace as a fallback check.
+// Pods are re-resolved to cgroup IDs after every resolver refresh.
+type cgroupFilter struct {
+	bpfMap   *ebpf.Map
+	resolver *cgroupmap.Resolver
+	ids      []uint64
+	pods     map[string]bool
+
+	mu      sync.Mutex // serializes sync
+	allowed atomic.Pointer[map[uint64]struct{}]
+}
+
+func newCgroupFilter(bpfMap *ebpf.Map, resolver *cgroupmap.Resolver, ids []uint64, pods []string) *cgroupFilter {
+	f := &cgroupFilter{
+		bpfMap:   bpfMap,
+		resolver: resolver,
+		ids:      ids,
+		pods:     make(map[string]bool, len(pods)),
+	}
+	for _, p := range pods {
+		f.pods[p] = true
+	}
+	empty := map[uint64]struct{}{}
+	f.allowed.Store(&empty)
+	return f
+}
+
+// allows reports whether events from cgroupID should be traced.
+func (f *cgroupFilter) allows(cgroupID uint64) bool {
+	_, ok := (*f.allowed.Load())[cgroupID]
+	return ok
+}
+
+// sync recomputes the allowed set and updates the BPF map to match.
+func (f *cgroupFilter) sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	want := make(map[uint64]struct{}, len(f.ids))
+	for _, id := range f.ids {
+		want[id] = struct{}{}
+	}
+	if len(f.pods) > 0 {
+		for id, info := range f.resolver.Snapshot() {
+			if f.pods[info.Pod] {
+				want[id] = struct{}{}
+			}
+		}
+	}
+	if len(want) > maxTracedCgroups {
+		return fmt.Errorf("%d cgroups selected for tracing, BPF allowlist holds %d", len(want), maxTracedCgroups)
+	}
+	f.allowed.Store(&want)
+
+	// Remove stale entries, then add new ones.
+	var key uint64
+	var val uint8
+	var stale []uint64
+	iter := f.bpfMap.Iterate()
+	for iter.Next(&key, &val) {
+		if _, ok := want[key]; !ok {
+			stale = append(stale, key)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("iterate trace cgroups: %w", err)
+	}
+	for _, id := range stale {
+		if err := f.bpfMap.Delete(&id); err != nil {
+			return fmt.Errorf("remove traced cgroup %d: %w", id, err)
+		}
+	}
+	one := uint8(1)
+	for id := range want {
+		if err := f.bpfMap.Update(&id, &one, ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("add traced cgroup %d: %w", id, err)
+		}
+	}
+
+	log.Printf("tracing: cgroup allowlist synced, %d cgroups", len(want))
+	return nil
+}
@@ -3,9 +3,15 @@ package tracing
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cilium/ebpf"
@@ -19,6 +25,10 @@ const (
 	OpAlloc    = 0
 	OpPositive = 1
 	OpNegative = 2
+	OpReclaim  = 3 // marker emitted on shrink_dcache_sb, carries no path
+
+	opUnknown = 4 // index for unrecognized operations in per-op counters
+	numOps    = 5
 )
 
 // TraceEvent is a dentry trace event received from the eBPF ring buffer.
@@ -30,8 +40,23 @@ type TraceEvent struct {
 	Operation string
 	Path      string
 	Fstype    string
+	Pid       uint32
+	HostPath  string // the path under /proc/<pid>/root, if it resolved
+	Type      string // dir, file, symlink, other, or unknown (alloc, negative)
 }
 
+// Event layout dimensions. These must match MAX_PATH_DEPTH, MAX_NAME_LEN and
+// MAX_FSTYPE_LEN in dentry.c; bumping them there and here is the only change
+// needed to capture deeper paths or longer names.
+const (
+	maxDepth  = 8
+	nameLen   = 64
+	fstypeLen = 16
+)
+
+// rawEventSize is the number of bytes parseRawEvent needs from a sample.
+var rawEventSize = binary.Size(rawTraceEvent{})
+
 // rawTraceEvent matches the eBPF struct dentry_trace_event layout.
 // Path components are stored leaf-to-root: names[0]=filename, names[1]=parent, etc.
 // Bit 31 of Depth is set if the walk reached the filesystem root.
@@ -40,8 +65,10 @@ type rawTraceEvent struct {
 	CgroupID  uint64
 	Operation uint32
 	Depth     uint32
-	Names     [8][64]byte
-	Fstype    [16]byte
+	Pid       uint32
+	Mode      uint32 // inode i_mode for positive instantiates, else 0
+	Names     [maxDepth][nameLen]byte
+	Fstype    [fstypeLen]byte
 }
 
 const depthRootFlag = 0x80000000
@@ -50,56 +77,282 @@ const depthRootFlag = 0x80000000
 type TraceConfig struct {
 	Enabled      bool
 	PathPatterns []string
+
+	// ExcludePatterns drop events whose path contains any of them. They are
+	// evaluated after PathPatterns and always win: an excluded path is dropped
+	// even if it matched an inclusion. With no PathPatterns, exclusions prune
+	// from "all paths".
+	ExcludePatterns []string
+
+	// NegativeOnly keeps only negative instantiates (plus reclaim markers).
+	// The kernel skips the other operations, and the consumer drops any that
+	// still arrive. Other filters apply on top.
+	NegativeOnly bool
+
+	// Types keeps only events whose TraceEvent.Type is listed (empty = all).
+	// Allocs and negative dentries have type "unknown".
+	Types []string
+
+	// SampleRates keeps each alloc/positive/negative event with the given
+	// probability, keyed by operation name; operations without an entry use
+	// SampleRate. Sampling runs after path filtering, so path classes still
+	// see every event. A zero SampleRate is treated as 1 (keep all).
+	SampleRates map[string]float64
+	SampleRate  float64
+
+	// PathClasses count events whose path contains a class pattern in the
+	// path_class metric, independently of PathPatterns/ExcludePatterns.
+	PathClasses []PathClass
+
+	// CgroupIDs and Pods restrict path tracing to the listed cgroups and to
+	// the cgroups of pods whose resolved name is listed. When either is set
+	// the kernel only emits events for those cgroups; pods are re-resolved
+	// after every resolver refresh.
+	CgroupIDs []uint64
+	Pods      []string
+
+	// ReclaimMarkers injects an OpReclaim event into the trace stream each
+	// time the kernel reclaims dentries. Markers bypass path filtering.
+	ReclaimMarkers bool
+
+	// ResolveHostPath enables best-effort resolution of each traced path
+	// against ProcRoot/<pid>/root, filling TraceEvent.HostPath. Negative
+	// dentries name no file and are skipped.
+	ResolveHostPath bool
+	ProcRoot        string
+
+	// DedupWindow collapses an alloc followed by a positive/negative
+	// instantiate for the same cgroup and leaf name within this window into
+	// one event carrying the final operation (0=off).
+	DedupWindow time.Duration
+
+	// EventKey selects how events are grouped for deduplication; one of
+	// EventKeys (empty = "leaf").
+	EventKey string
+
+	// Workers is the number of goroutines processing events (resolve,
+	// filter, write). Events are partitioned by cgroup ID, so each cgroup's
+	// events stay in order, but events of different cgroups may be written
+	// out of order relative to each other. 0 or 1 uses a single worker,
+	// preserving global order.
+	Workers int
+
+	// QueueSize bounds the samples buffered per worker between the ring
+	// buffer reader and processing. When a worker's queue is full the sample
+	// is dropped and counted rather than blocking the reader, so the kernel
+	// ring buffer keeps absorbing the real backlog (0 = defaultQueueSize).
+	QueueSize int
+
+	// PollTimeout bounds each ring buffer read so the consumer wakes up to
+	// check for shutdown at least this often (0 = block until the reader
+	// is closed from another goroutine).
+	PollTimeout time.Duration
+
+	// DisableAfter turns tracing off automatically this long after it was
+	// enabled, as a safety rail for ad-hoc debugging (0 = never).
+	DisableAfter time.Duration
+
+	// MetricPrefix is the name prefix of the pipeline metrics
+	// (default "dentry").
+	MetricPrefix string
+
+	// MetricLabels are constant labels added to the pipeline metrics.
+	MetricLabels map[string]string
 }
 
 // bpfTraceConfig matches the eBPF struct trace_config layout.
 type bpfTraceConfig struct {
 	Enabled uint32
-	Pad     uint32
+	Flags   uint32
 }
 
+// Flags for bpfTraceConfig.Flags, matching TRACE_FLAG_* in dentry.c.
+const (
+	traceFlagReclaimMarkers = 1 << 0
+	traceFlagCgroupFilter   = 1 << 1
+	traceFlagNegativeOnly   = 1 << 2
+)
+
 // Consumer reads trace events from the BPF ring buffer and writes them to a TSV file.
 type Consumer struct {
 	ringbufMap *ebpf.Map
 	configMap  *ebpf.Map
 	resolver   *cgroupmap.Resolver
-	config     TraceConfig
-	writer     *TSVWriter
+
+	mu        sync.Mutex // guards config.Enabled, the TTL state, started and closed
+	config    TraceConfig
+	ttl       *time.Timer
+	disableAt time.Time     // zero when no TTL is pending
+	started   bool          // Start has run (or is running)
+	closed    bool          // Close has been called; Start becomes a no-op
+	done      chan struct{} // closed when Start has fully returned
+
+	writer     *TSVWriter    // nil disables file output
+	dedup      *deduper      // nil when DedupWindow is 0
+	eventKey   func(TraceEvent) string
+	cgroups    *cgroupFilter // nil when no cgroup/pod allowlist
+
+	metrics   pipelineMetrics
+	collapsed atomic.Uint64
+	opCounts  [numOps]atomic.Uint64 // every parsed event, before filtering
+	classes   *pathClassCounts
+	types     map[string]bool // nil when Types is empty
+	rates     [numOps]float64 // per-operation sample rates
+	drops     [numDropReasons]atomic.Uint64
+	queues    []chan []byte // one per worker, fixed at construction
 }
 
+// defaultQueueSize is the per-worker queue length used when QueueSize is 0.
+const defaultQueueSize = 1024
+
 // NewConsumer creates a trace event consumer that writes to the given TSV writer.
+// A nil writer disables file output; events are still consumed and counted.
 // It applies the trace config to the eBPF config map immediately.
-func NewConsumer(ringbufMap, configMap *ebpf.Map, resolver *cgroupmap.Resolver, cfg TraceConfig, writer *TSVWriter) (*Consumer, error) {
+// cgroupMap is the BPF cgroup allowlist used when CgroupIDs or Pods is set.
+func NewConsumer(ringbufMap, configMap, cgroupMap *ebpf.Map, resolver *cgroupmap.Resolver, cfg TraceConfig, writer *TSVWriter) (*Consumer, error) {
+	prefix := cfg.MetricPrefix
+	if prefix == "" {
+		prefix = "dentry"
+	}
 	c := &Consumer{
+		done:       make(chan struct{}),
 		ringbufMap: ringbufMap,
 		configMap:  configMap,
 		resolver:   resolver,
 		config:     cfg,
 		writer:     writer,
+		metrics:    newPipelineMetrics(prefix, cfg.MetricLabels),
+		classes:    newPathClassCounts(cfg.PathClasses),
+	}
+	if cfg.DedupWindow > 0 {
+		c.dedup = newDeduper(cfg.DedupWindow)
+	}
+	var err error
+	if c.eventKey, err = eventKeyFunc(cfg.EventKey); err != nil {
+		return nil, err
+	}
+	size := cfg.QueueSize
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	c.queues = make([]chan []byte, max(cfg.Workers, 1))
+	for i := range c.queues {
+		c.queues[i] = make(chan []byte, size)
+	}
+	def := cfg.SampleRate
+	if def == 0 {
+		def = 1
+	}
+	c.rates = sampleRates(cfg.SampleRates, def)
+	if len(cfg.Types) > 0 {
+		c.types = make(map[string]bool, len(cfg.Types))
+		for _, t := range cfg.Types {
+			c.types[t] = true
+		}
+	}
+	if len(cfg.CgroupIDs) > 0 || len(cfg.Pods) > 0 {
+		c.cgroups = newCgroupFilter(cgroupMap, resolver, cfg.CgroupIDs, cfg.Pods)
+		if err := c.cgroups.sync(); err != nil {
+			return nil, fmt.Errorf("sync cgroup allowlist: %w", err)
+		}
+		resolver.OnRefresh(func() {
+			if err := c.cgroups.sync(); err != nil {
+				log.Printf("tracing: %v", err)
+			}
+		})
 	}
 	if err := c.applyBPFConfig(); err != nil {
 		return nil, fmt.Errorf("apply trace config: %w", err)
 	}
+	if cfg.Enabled && cfg.DisableAfter > 0 {
+		c.armTTL()
+	}
 	return c, nil
 }
 
+// Config returns the trace config the consumer is running with.
+func (c *Consumer) Config() TraceConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.config
+}
+
+// DisableIn returns the time left before the DisableAfter TTL turns tracing
+// off, or 0 if none is pending.
+func (c *Consumer) DisableIn() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.disableAt.IsZero() {
+		return 0
+	}
+	return time.Until(c.disableAt)
+}
+
+// armTTL (re)starts the DisableAfter timer; each enable resets the window.
+func (c *Consumer) armTTL() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl != nil {
+		c.ttl.Stop()
+	}
+	c.disableAt = time.Now().Add(c.config.DisableAfter)
+	c.ttl = time.AfterFunc(c.config.DisableAfter, c.expireTTL)
+	log.Printf("tracing: will be disabled automatically at %s", c.disableAt.Format(time.RFC3339))
+}
+
+// expireTTL turns tracing off when the DisableAfter window ends.
+func (c *Consumer) expireTTL() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.disableAt = time.Time{}
+	c.config.Enabled = false
+	if err := c.applyBPFConfig(); err != nil {
+		log.Printf("tracing: failed to disable after %s: %v", c.config.DisableAfter, err)
+		return
+	}
+	log.Printf("tracing: disabled after %s (-trace-disable-after)", c.config.DisableAfter)
+}
+
 // applyBPFConfig pushes the trace config to the eBPF config map.
+// Callers other than NewConsumer must hold c.mu.
 func (c *Consumer) applyBPFConfig() error {
 	var bpfCfg bpfTraceConfig
 	if c.config.Enabled {
 		bpfCfg.Enabled = 1
 	}
+	if c.config.ReclaimMarkers {
+		bpfCfg.Flags |= traceFlagReclaimMarkers
+	}
+	if c.cgroups != nil {
+		bpfCfg.Flags |= traceFlagCgroupFilter
+	}
+	if c.config.NegativeOnly {
+		bpfCfg.Flags |= traceFlagNegativeOnly
+	}
 	var key uint32
 	if err := c.configMap.Update(&key, &bpfCfg, ebpf.UpdateAny); err != nil {
 		return err
 	}
-	log.Printf("tracing: config applied: enabled=%v patterns=%v", c.config.Enabled, c.config.PathPatterns)
+	log.Printf("tracing: config applied: enabled=%v patterns=%v exclude=%v reclaim_markers=%v cgroups=%v pods=%v negative_only=%v",
+		c.config.Enabled, c.config.PathPatterns, c.config.ExcludePatterns, c.config.ReclaimMarkers,
+		c.config.CgroupIDs, c.config.Pods, c.config.NegativeOnly)
 	return nil
 }
 
 // Start begins consuming ring buffer events and writing them to the TSV file.
-// Blocks until stopCh is closed.
+// Blocks until stopCh is closed. With a PollTimeout, shutdown completes
+// within one timeout of stopCh closing. Start returns only after every
+// queued event has been processed and its helper goroutines have exited.
 func (c *Consumer) Start(stopCh <-chan struct{}) {
+	c.mu.Lock()
+	if c.closed || c.started {
+		c.mu.Unlock()
+		return
+	}
+	c.started = true
+	c.mu.Unlock()
+	defer close(c.done)
+
 	rd, err := ringbuf.NewReader(c.ringbufMap)
 	if err != nil {
 		log.Printf("tracing: failed to create ring buffer reader: %v", err)
@@ -107,31 +360,93 @@ func (c *Consumer) Start(stopCh <-chan struct{}) {
 	}
 	defer rd.Close()
 
+	// Flush and dedup-expiry goroutines touch the writer, so Start waits
+	// for them (after stopCh closes) before reporting done.
+	var bg sync.WaitGroup
+	defer bg.Wait()
+
 	// Periodic flush
-	flushTicker := time.NewTicker(1 * time.Second)
-	defer flushTicker.Stop()
+	if c.writer != nil {
+		bg.Add(1)
+		go func() {
+			defer bg.Done()
+			flushTicker := time.NewTicker(1 * time.Second)
+			defer flushTicker.Stop()
+			for {
+				select {
+				case <-flushTicker.C:
+					if err := c.writer.Flush(); err != nil {
+						log.Printf("tracing: flush error: %v", err)
+					}
+				case <-stopCh:
+					return
+				}
+			}
+		}()
+	}
 
-	go func() {
-		for {
-			select {
-			case <-flushTicker.C:
-				if err := c.writer.Flush(); err != nil {
-					log.Printf("tracing: flush error: %v", err)
+	if c.dedup != nil {
+		bg.Add(1)
+		go func() {
+			defer bg.Done()
+			ticker := time.NewTicker(c.config.DedupWindow)
+			defer ticker.Stop()
+			for {
+				select {
+				case now := <-ticker.C:
+					c.writeEvents(c.dedup.expire(now))
+				case <-stopCh:
+					return
 				}
-			case <-stopCh:
-				return
 			}
+		}()
+	}
+
+	// The reader only enqueues; workers drain the queues until Start returns.
+	// This defer runs before bg.Wait and close(done), so every queued
+	// sample is processed before Close can shut the writer.
+	var wg sync.WaitGroup
+	for _, q := range c.queues {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for data := range q {
+				c.process(data)
+			}
+		}()
+	}
+	defer func() {
+		for _, q := range c.queues {
+			close(q)
 		}
+		wg.Wait()
 	}()
+	if len(c.queues) > 1 {
+		log.Printf("tracing: %d workers, partitioned by cgroup ID", len(c.queues))
+	}
 
-	go func() {
-		<-stopCh
-		rd.Close()
-	}()
+	if c.config.PollTimeout <= 0 {
+		go func() {
+			<-stopCh
+			rd.Close()
+		}()
+	}
 
 	for {
+		if c.config.PollTimeout > 0 {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+			rd.SetDeadline(time.Now().Add(c.config.PollTimeout))
+		}
+
 		record, err := rd.Read()
 		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				continue
+			}
 			select {
 			case <-stopCh:
 				return
@@ -141,43 +456,188 @@ func (c *Consumer) Start(stopCh <-chan struct{}) {
 			}
 		}
 
-		evt, err := parseRawEvent(record.RawSample)
-		if err != nil {
-			continue
-		}
+		c.dispatch(record.RawSample)
+	}
+}
 
-		// Resolve cgroup to pod
-		info := c.resolver.Resolve(evt.CgroupID)
-		path := buildPath(evt)
+// dispatch queues a sample for the worker owning its cgroup, dropping it
+// if that worker's queue is full.
+func (c *Consumer) dispatch(data []byte) {
+	// CgroupID is the second field of rawTraceEvent; short samples land on
+	// worker 0 and are counted as parse errors there.
+	var cgroupID uint64
+	if len(data) >= 16 {
+		cgroupID = binary.LittleEndian.Uint64(data[8:16])
+	}
+	select {
+	case c.queues[cgroupID%uint64(len(c.queues))] <- data:
+	default:
+		c.drop(dropQueueFull)
+	}
+}
+
+// queueDepth returns the number of samples waiting in all worker queues.
+func (c *Consumer) queueDepth() int {
+	n := 0
+	for _, q := range c.queues {
+		n += len(q)
+	}
+	return n
+}
+
+// process filters one raw ring buffer sample and writes the resulting
+// event. It is safe to call from several workers at once.
+func (c *Consumer) process(data []byte) {
+	evt, err := parseRawEvent(data)
+	if err != nil {
+		c.drop(dropParseError)
+		return
+	}
+	c.countOp(evt.Operation)
+
+	// Userspace fallback for the kernel-side negative-only filter
+	if c.config.NegativeOnly && (evt.Operation == OpAlloc || evt.Operation == OpPositive) {
+		c.drop(dropOperationFilter)
+		return
+	}
+
+	// Userspace fallback for the kernel-side cgroup allowlist
+	if c.cgroups != nil && evt.Operation != OpReclaim && !c.cgroups.allows(evt.CgroupID) {
+		c.drop(dropCgroupFilter)
+		return
+	}
+
+	// Resolve cgroup to pod
+	info := c.resolver.Resolve(evt.CgroupID)
+	var path string
+	if evt.Operation != OpReclaim {
+		path = buildPath(evt)
+		c.classes.observe(path, evt.Operation)
 
 		// Userspace pattern filtering
-		if len(c.config.PathPatterns) > 0 && !matchesAnyPattern(path, c.config.PathPatterns) {
-			continue
+		if allowed, _, _ := FilterPath(path, c.config.PathPatterns, c.config.ExcludePatterns); !allowed {
+			c.drop(dropPatternMismatch)
+			return
 		}
+	}
+	if !c.sampled(evt.Operation) {
+		c.drop(dropSampledOut)
+		return
+	}
 
-		var traceEvt TraceEvent
-		traceEvt.Timestamp = time.Now()
-		traceEvt.CgroupID = evt.CgroupID
-		traceEvt.Operation = opName(evt.Operation)
-		traceEvt.Path = path
-		traceEvt.Fstype = extractString(evt.Fstype[:])
+	var traceEvt TraceEvent
+	traceEvt.Timestamp = time.Now()
+	traceEvt.CgroupID = evt.CgroupID
+	traceEvt.Operation = opName(evt.Operation)
+	traceEvt.Path = path
+	traceEvt.Fstype = extractString(evt.Fstype[:])
+	traceEvt.Pid = evt.Pid
+	traceEvt.Type = inodeType(evt.Mode)
+	if c.types != nil && !c.types[traceEvt.Type] && evt.Operation != OpReclaim {
+		c.drop(dropTypeFilter)
+		return
+	}
 
-		if info != nil {
-			traceEvt.Pod = info.Pod
-			traceEvt.Container = info.Container
-		}
+	if c.config.ResolveHostPath && evt.Operation != OpNegative {
+		traceEvt.HostPath = resolveHostPath(c.config.ProcRoot, evt.Pid, path)
+	}
 
-		if err := c.writer.WriteEvent(traceEvt); err != nil {
-			log.Printf("tracing: write error: %v", err)
-		}
+	if info != nil {
+		traceEvt.Pod = info.Pod
+		traceEvt.Container = info.Container
+	}
+
+	if c.dedup == nil {
+		c.writeEvent(traceEvt)
+		return
+	}
+	out, collapsed := c.dedup.offer(traceEvt, c.eventKey(traceEvt), time.Now())
+	if collapsed {
+		c.collapsed.Add(1)
+	}
+	c.writeEvents(out)
+}
+
+func (c *Consumer) countOp(op uint32) {
+	if op >= opUnknown {
+		op = opUnknown
 	}
+	c.opCounts[op].Add(1)
 }
 
-// Close flushes and closes the TSV writer.
+func (c *Consumer) writeEvent(evt TraceEvent) {
+	if c.writer == nil {
+		return
+	}
+	if err := c.writer.WriteEvent(evt); err != nil {
+		c.drop(dropWriteError)
+		log.Printf("tracing: write error: %v", err)
+	}
+}
+
+func (c *Consumer) writeEvents(evts []TraceEvent) {
+	for _, evt := range evts {
+		c.writeEvent(evt)
+	}
+}
+
+// Close waits for a running Start to return (the caller must have closed
+// its stopCh), then releases any allocs held for deduplication and flushes
+// and closes the TSV writer. No event is written after Close returns.
 func (c *Consumer) Close() error {
+	c.mu.Lock()
+	if c.ttl != nil {
+		c.ttl.Stop()
+	}
+	c.closed = true
+	started := c.started
+	c.mu.Unlock()
+	if started {
+		<-c.done
+	}
+	if c.dedup != nil {
+		c.writeEvents(c.dedup.drain())
+	}
+	if c.writer == nil {
+		return nil
+	}
 	return c.writer.Close()
 }
 
+// File type bits of i_mode (S_IFMT and friends).
+const (
+	modeTypeMask = 0170000
+	modeDir      = 0040000
+	modeRegular  = 0100000
+	modeSymlink  = 0120000
+)
+
+// EventTypes lists the values of TraceEvent.Type.
+var EventTypes = []string{"dir", "file", "symlink", "other", "unknown"}
+
+// inodeType decodes the file type from an inode mode. A zero mode means the
+// kernel had no inode (allocs and negative dentries).
+func inodeType(mode uint32) string {
+	if mode == 0 {
+		return "unknown"
+	}
+	switch mode & modeTypeMask {
+	case modeDir:
+		return "dir"
+	case modeRegular:
+		return "file"
+	case modeSymlink:
+		return "symlink"
+	default:
+		return "other"
+	}
+}
+
+// UnlinkedPath is the path of an event for which the kernel captured no name
+// at all (depth 0), typically an alloc whose name could not be read. It is
+// distinct from "/", which is only used for the filesystem root itself.
+const UnlinkedPath = "<unlinked>"
+
 // buildPath reconstructs a path from the name components.
 // Components are stored leaf-to-root, so we reverse them.
 // Leading "/" means the path reached the real filesystem root (ext4/xfs/btrfs).
@@ -185,8 +645,11 @@ func (c *Consumer) Close() error {
 func buildPath(evt *rawTraceEvent) string {
 	reachedRoot := evt.Depth&depthRootFlag != 0
 	depth := int(evt.Depth &^ depthRootFlag)
-	if depth > 8 {
-		depth = 8
+	if depth == 0 {
+		return UnlinkedPath
+	}
+	if depth > maxDepth {
+		depth = maxDepth
 	}
 	parts := make([]string, 0, depth)
 	for i := depth - 1; i >= 0; i-- {
@@ -208,7 +671,31 @@ func buildPath(evt *rawTraceEvent) string {
 	return strings.Join(parts, "/")
 }
 
+// resolveHostPath maps a path from the traced task's mount namespace to
+// <procRoot>/<pid>/root/<path>, which the node can open only while the task
+// lives; it is not a stable path. The task may have exited by
+// the time the event is processed, so liveness is checked first and an empty
+// string is returned if the process or the path no longer exists.
+// Partial (non-rooted) paths cannot be anchored and are skipped.
+func resolveHostPath(procRoot string, pid uint32, path string) string {
+	if pid == 0 || !strings.HasPrefix(path, "/") {
+		return ""
+	}
+	pidDir := filepath.Join(procRoot, strconv.FormatUint(uint64(pid), 10))
+	if _, err := os.Stat(pidDir); err != nil {
+		return ""
+	}
+	hostPath := filepath.Join(pidDir, "root", path)
+	if _, err := os.Lstat(hostPath); err != nil {
+		return ""
+	}
+	return hostPath
+}
+
 func parseRawEvent(data []byte) (*rawTraceEvent, error) {
+	if len(data) < rawEventSize {
+		return nil, fmt.Errorf("short trace event: %d bytes, want %d", len(data), rawEventSize)
+	}
 	var evt rawTraceEvent
 	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &evt); err != nil {
 		return nil, err
@@ -224,6 +711,8 @@ func opName(op uint32) string {
 		return "positive"
 	case OpNegative:
 		return "negative"
+	case OpReclaim:
+		return "reclaim"
 	default:
 		return "unknown"
 	}
@@ -249,11 +738,43 @@ func contains(s, substr string) bool {
 	return false
 }
 
-func matchesAnyPattern(path string, patterns []string) bool {
+// MatchPattern reports which of patterns matches path, using the same
+// substring semantics as the consumer's filter. An empty pattern list
+// matches every path (with an empty matched pattern).
+func MatchPattern(path string, patterns []string) (matched string, ok bool) {
+	if len(patterns) == 0 {
+		return "", true
+	}
 	for _, pat := range patterns {
 		if containsSubstring(path, pat) {
-			return true
+			return pat, true
 		}
 	}
-	return false
+	return "", false
+}
+
+// FilterPath applies inclusion then exclusion patterns to path and reports
+// whether it would be traced, which inclusion matched, and which exclusion
+// (if any) dropped it.
+func FilterPath(path string, include, exclude []string) (allowed bool, included, excludedBy string) {
+	included, ok := MatchPattern(path, include)
+	if !ok {
+		return false, "", ""
+	}
+	for _, pat := range exclude {
+		if containsSubstring(path, pat) {
+			return false, included, pat
+		}
+	}
+	return true, included, ""
+}
+
+// ValidatePatterns rejects patterns the filter can never match.
+func ValidatePatterns(patterns []string) error {
+	for i, pat := range patterns {
+		if pat == "" {
+			return fmt.Errorf("pattern %d is empty", i)
+		}
+	}
+	return nil
 }
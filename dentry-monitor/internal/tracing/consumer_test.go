@@ -0,0 +1,354 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+
+	"github.com/rophy/mem-psi-test/dentry-monitor/internal/cgroupmap"
+)
+
+// newTestConsumer builds a Consumer on real (unattached) BPF maps, writing
+// to a TSV file in a temp dir. It skips the test when the kernel or the
+// process can't create BPF maps.
+func newTestConsumer(t testing.TB, cfg TraceConfig) (*Consumer, string) {
+	t.Helper()
+	ringbufMap, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.RingBuf, MaxEntries: 1 << 16})
+	if err != nil {
+		t.Skipf("cannot create BPF ring buffer: %v", err)
+	}
+	t.Cleanup(func() { ringbufMap.Close() })
+	configMap, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 1})
+	if err != nil {
+		t.Skipf("cannot create BPF array: %v", err)
+	}
+	t.Cleanup(func() { configMap.Close() })
+
+	dir := t.TempDir()
+	writer, err := NewTSVWriter(WriterConfig{Dir: dir, MaxSize: 1 << 20, MaxFiles: 1, UID: -1, GID: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := cgroupmap.NewResolver(t.TempDir(), t.TempDir(), cgroupmap.Options{})
+	c, err := NewConsumer(ringbufMap, configMap, nil, resolver, cfg, writer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, dir
+}
+
+func TestCloseWaitsForStart(t *testing.T) {
+	const poll = 100 * time.Millisecond
+	c, _ := newTestConsumer(t, TraceConfig{PollTimeout: poll})
+
+	stopCh := make(chan struct{})
+	returned := make(chan struct{})
+	go func() {
+		c.Start(stopCh)
+		close(returned)
+	}()
+	waitStarted(t, c)
+	time.Sleep(poll / 2) // let Start block in a ring buffer read
+
+	begin := time.Now()
+	close(stopCh)
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	select {
+	case <-returned:
+	default:
+		t.Fatal("Close returned before Start")
+	}
+	if took := time.Since(begin); took > poll+time.Second {
+		t.Errorf("shutdown took %s, want about one poll timeout (%s)", took, poll)
+	}
+}
+
+func TestCloseWithoutStart(t *testing.T) {
+	c, _ := newTestConsumer(t, TraceConfig{PollTimeout: 10 * time.Millisecond})
+	closed := make(chan error)
+	go func() { closed <- c.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked although Start never ran")
+	}
+	// A Start that races in after Close must not touch the closed writer.
+	c.Start(make(chan struct{}))
+}
+
+// rawSample encodes a negative-dentry event for /<name> in cgroupID.
+func rawSample(t testing.TB, cgroupID uint64, name string) []byte {
+	t.Helper()
+	return rawOpSample(t, cgroupID, OpNegative, name)
+}
+
+// rawOpSample encodes an op event for /<name> in cgroupID.
+func rawOpSample(t testing.TB, cgroupID uint64, op uint32, name string) []byte {
+	t.Helper()
+	evt := rawTraceEvent{CgroupID: cgroupID, Operation: op, Depth: 1 | depthRootFlag}
+	copy(evt.Names[0][:], name)
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, &evt); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readRows returns the data rows of the trace file in dir.
+func readRows(t *testing.T, dir string) []string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(dir, defaultFileTemplate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	return lines[1:] // header
+}
+
+// waitStarted blocks until Start has claimed the consumer, so a following
+// Close waits for it instead of turning it into a no-op.
+func waitStarted(t testing.TB, c *Consumer) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.mu.Lock()
+		started := c.started
+		c.mu.Unlock()
+		if started {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Start did not run")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCloseDrainsQueues(t *testing.T) {
+	const n = 500
+	c, dir := newTestConsumer(t, TraceConfig{PollTimeout: 10 * time.Millisecond, Workers: 4, QueueSize: n})
+
+	stopCh := make(chan struct{})
+	go c.Start(stopCh)
+	waitStarted(t, c)
+	for i := 0; i < n; i++ {
+		c.dispatch(rawSample(t, uint64(i%8), fmt.Sprintf("f%d", i)))
+	}
+	close(stopCh)
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if got := len(readRows(t, dir)); got != n {
+		t.Errorf("wrote %d rows, want %d", got, n)
+	}
+	for r := dropReason(0); r < numDropReasons; r++ {
+		if d := c.drops[r].Load(); d != 0 {
+			t.Errorf("%d events dropped as %s", d, dropReasonNames[r])
+		}
+	}
+}
+
+// TestRawEventLayout checks rawTraceEvent against struct dentry_trace_event:
+// timestamp, cgroup_id, then operation, depth, pid and mode as u32, then the
+// name slots and fstype. A field added on one side only shows up here.
+func TestRawEventLayout(t *testing.T) {
+	want := 8 + 8 + 4*4 + maxDepth*nameLen + fstypeLen
+	if got := binary.Size(rawTraceEvent{}); got != want {
+		t.Errorf("binary.Size(rawTraceEvent{}) = %d, want %d", got, want)
+	}
+	if rawEventSize != want {
+		t.Errorf("rawEventSize = %d, want %d", rawEventSize, want)
+	}
+}
+
+func TestBuildPath(t *testing.T) {
+	tests := []struct {
+		name  string
+		depth uint32
+		names []string // leaf first, as the kernel stores them
+		want  string
+	}{
+		{"unlinked", 0, nil, UnlinkedPath},
+		{"unlinked with root flag", depthRootFlag, nil, UnlinkedPath},
+		{"leaf only", 1, []string{"file"}, "file"},
+		{"partial", 3, []string{"file", "b", "a"}, "a/b/file"},
+		{"rooted", 3 | depthRootFlag, []string{"file", "b", "a"}, "/a/b/file"},
+		{"rooted with root slot", 3 | depthRootFlag, []string{"file", "etc", "/"}, "/etc/file"},
+		{"filesystem root", 1 | depthRootFlag, []string{"/"}, "/"},
+		{"depth clamped", maxDepth + 5, []string{"h", "g", "f", "e", "d", "c", "b", "a"}, "a/b/c/d/e/f/g/h"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evt := rawTraceEvent{Depth: tt.depth}
+			for i, n := range tt.names {
+				copy(evt.Names[i][:], n)
+			}
+			if got := buildPath(&evt); got != tt.want {
+				t.Errorf("buildPath = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterPath(t *testing.T) {
+	tests := []struct {
+		name             string
+		include, exclude []string
+		path             string
+		allowed          bool
+		included, by     string
+	}{
+		{"no patterns", nil, nil, "/etc/hosts", true, "", ""},
+		{"include match", []string{"/var/", "/etc/"}, nil, "/etc/hosts", true, "/etc/", ""},
+		{"include miss", []string{"/var/"}, nil, "/etc/hosts", false, "", ""},
+		{"exclude match", nil, []string{"/proc/"}, "/proc/1/stat", false, "", "/proc/"},
+		{"exclude miss", nil, []string{"/proc/"}, "/etc/hosts", true, "", ""},
+		{"both, kept", []string{"/etc/"}, []string{".swp"}, "/etc/hosts", true, "/etc/", ""},
+		{"both, excluded", []string{"/etc/"}, []string{".swp"}, "/etc/.hosts.swp", false, "/etc/", ".swp"},
+		{"both, not included", []string{"/etc/"}, []string{".swp"}, "/var/.log.swp", false, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, included, by := FilterPath(tt.path, tt.include, tt.exclude)
+			if allowed != tt.allowed || included != tt.included || by != tt.by {
+				t.Errorf("FilterPath(%q) = %v, %q, %q; want %v, %q, %q",
+					tt.path, allowed, included, by, tt.allowed, tt.included, tt.by)
+			}
+		})
+	}
+}
+
+func TestParsePathClasses(t *testing.T) {
+	tooMany := make([]string, MaxPathClasses+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("c%d=/p%d/", i, i)
+	}
+	tests := []struct {
+		in      string
+		want    []PathClass
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"cache-miss=/cache/", []PathClass{{"cache-miss", "/cache/"}}, false},
+		{"a=/x/,b=k=v", []PathClass{{"a", "/x/"}, {"b", "k=v"}}, false},
+		{"nopattern", nil, true},
+		{"=/cache/", nil, true},
+		{"cache=", nil, true},
+		{"a=/x/,a=/y/", nil, true},
+		{strings.Join(tooMany[:MaxPathClasses], ","), nil, false},
+		{strings.Join(tooMany, ","), nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePathClasses(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePathClasses(%.40q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePathClasses(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPathClassFirstMatch(t *testing.T) {
+	classes := []PathClass{{"cache", "/cache/"}, {"tmp", "/tmp/"}, {"sql", "#sql"}}
+	tests := []struct {
+		path string
+		want string // class counted, "" for none
+	}{
+		{"/var/cache/x", "cache"},
+		{"/tmp/cache/x", "cache"}, // matches cache and tmp: first wins
+		{"/tmp/#sql-1", "tmp"},
+		{"/data/#sql-1", "sql"},
+		{"/etc/hosts", ""},
+	}
+	for _, tt := range tests {
+		p := newPathClassCounts(classes)
+		p.observe(tt.path, OpNegative)
+		for i, pc := range classes {
+			want := uint64(0)
+			if pc.Name == tt.want {
+				want = 1
+			}
+			if got := p.counts[i][OpNegative].Load(); got != want {
+				t.Errorf("%s: class %s counted %d, want %d", tt.path, pc.Name, got, want)
+			}
+		}
+	}
+}
+
+// TestWorkersKeepCgroupOrder checks that with several workers each cgroup's
+// events are still written in the order they were read.
+func TestWorkersKeepCgroupOrder(t *testing.T) {
+	const n, cgroups = 2000, 8
+	c, dir := newTestConsumer(t, TraceConfig{PollTimeout: 10 * time.Millisecond, Workers: 4, QueueSize: n})
+
+	stopCh := make(chan struct{})
+	go c.Start(stopCh)
+	waitStarted(t, c)
+	for i := 0; i < n; i++ {
+		c.dispatch(rawSample(t, uint64(i%cgroups), fmt.Sprintf("%d", i)))
+	}
+	close(stopCh)
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	rows := readRows(t, dir)
+	if len(rows) != n {
+		t.Fatalf("wrote %d rows, want %d", len(rows), n)
+	}
+	last := map[string]int{}
+	for _, row := range rows {
+		f := strings.Split(row, "\t")
+		cg := f[3]
+		var seq int
+		if _, err := fmt.Sscanf(f[5], "/%d", &seq); err != nil {
+			t.Fatalf("row %q: %v", row, err)
+		}
+		if prev, ok := last[cg]; ok && seq < prev {
+			t.Fatalf("cgroup %s: event %d written after %d", cg, seq, prev)
+		}
+		last[cg] = seq
+	}
+}
+
+// BenchmarkProcess measures end-to-end processing throughput (parse,
+// resolve, filter, write) for different worker counts.
+func BenchmarkProcess(b *testing.B) {
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			c, _ := newTestConsumer(b, TraceConfig{PollTimeout: 10 * time.Millisecond, Workers: workers})
+			samples := make([][]byte, 64)
+			for i := range samples {
+				samples[i] = rawSample(b, uint64(i), fmt.Sprintf("file%d", i))
+			}
+
+			stopCh := make(chan struct{})
+			go c.Start(stopCh)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// Block instead of dropping when a queue is full.
+				c.queues[i%len(samples)%len(c.queues)] <- samples[i%len(samples)]
+			}
+			close(stopCh)
+			if err := c.Close(); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
@@ -2,25 +2,79 @@ package tracing
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 const (
-	tsvHeader  = "timestamp\tpod\tcontainer\tcgroup_id\toperation\tpath\tfstype\n"
+	defaultFileTemplate = "traces.tsv"
+	tsvHeader  = "timestamp\tpod\tcontainer\tcgroup_id\toperation\tpath\tfstype\tpid\thost_path\ttype\n"
 	tsvBufSize = 64 * 1024 // 64 KB write buffer
 )
 
+// WriterConfig controls where and how trace files are written.
+type WriterConfig struct {
+	Dir      string
+	MaxSize  int64 // bytes before rotation
+	MaxFiles int   // rotated files to keep
+
+	// DirMode and FileMode are the permissions of Dir and the trace files.
+	// When set they are enforced on existing paths too; when 0, new paths
+	// get 0755/0644 and existing ones are left as the operator set them.
+	DirMode  os.FileMode
+	FileMode os.FileMode
+	UID, GID int         // owner of Dir and trace files (-1 = unchanged)
+
+	// FileTemplate names the trace file (default "traces.tsv").
+	// {node} expands to NodeName and {date} to the UTC start date (YYYYMMDD);
+	// both are fixed when the writer is created so rotation stays consistent.
+	// {index} is the rotation index (0 = active); without it rotated files
+	// get a ".N" suffix.
+	FileTemplate string
+	NodeName     string
+
+	// TimeFormat is TimeFormatRFC3339 (default) or TimeFormatEpoch for
+	// Unix nanoseconds in the timestamp column.
+	TimeFormat string
+
+	// MaxEventSize caps a serialized row in bytes (0 = unlimited). Longer
+	// rows drop host_path and keep only the tail of path, prefixed with
+	// TruncatedMarker.
+	MaxEventSize int
+}
+
+// TruncatedMarker prefixes a path shortened to fit WriterConfig.MaxEventSize.
+const TruncatedMarker = "[truncated]"
+
+// Timestamp formats for WriterConfig.TimeFormat.
+const (
+	TimeFormatRFC3339 = "rfc3339"
+	TimeFormatEpoch   = "epoch"
+)
+
 // TSVWriter writes trace events to tab-separated files with size-based rotation.
 type TSVWriter struct {
 	dir      string
-	baseName string
+	baseName string // expanded FileTemplate, may still contain {index}
+	indexed  bool   // baseName contains {index}
 	maxSize  int64
 	maxFiles int
+	fileMode os.FileMode
+	setMode  bool // FileMode was configured: enforce it on existing files
+	uid, gid int
+	epoch    bool // timestamps as Unix nanoseconds
+	maxEvent int  // max row size in bytes, 0 = unlimited
+
+	truncated atomic.Uint64 // rows whose path was shortened to fit maxEvent
 
 	mu      sync.Mutex
 	file    *os.File
@@ -28,19 +82,59 @@ type TSVWriter struct {
 	curSize int64
 }
 
-// NewTSVWriter creates a TSV writer that writes to dir/traces.tsv with rotation.
-// maxSize is the maximum file size in bytes before rotation.
-// maxFiles is the number of rotated files to keep.
-func NewTSVWriter(dir string, maxSize int64, maxFiles int) (*TSVWriter, error) {
-	if err := os.MkdirAll(dir, 0755); err != nil {
+// NewTSVWriter creates a TSV writer that writes to cfg.Dir/<FileTemplate> with rotation.
+// Paths it creates get the configured (or default) modes, applied explicitly
+// so they are not narrowed by umask. Existing paths are only chmod'ed or
+// chown'ed when a mode or owner was configured.
+func NewTSVWriter(cfg WriterConfig) (*TSVWriter, error) {
+	setDirMode, setFileMode := cfg.DirMode != 0, cfg.FileMode != 0
+	if !setDirMode {
+		cfg.DirMode = 0755
+	}
+	if !setFileMode {
+		cfg.FileMode = 0644
+	}
+	if err := validateModes(cfg.DirMode, cfg.FileMode); err != nil {
+		return nil, err
+	}
+	switch cfg.TimeFormat {
+	case "", TimeFormatRFC3339, TimeFormatEpoch:
+	default:
+		return nil, fmt.Errorf("invalid trace time format %q: must be %q or %q", cfg.TimeFormat, TimeFormatRFC3339, TimeFormatEpoch)
+	}
+	baseName, err := expandTemplate(cfg.FileTemplate, cfg.NodeName, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	_, statErr := os.Stat(cfg.Dir)
+	created := errors.Is(statErr, os.ErrNotExist)
+	if err := os.MkdirAll(cfg.Dir, cfg.DirMode); err != nil {
 		return nil, fmt.Errorf("create trace dir: %w", err)
 	}
+	if created || setDirMode {
+		if err := os.Chmod(cfg.Dir, cfg.DirMode); err != nil {
+			return nil, fmt.Errorf("chmod trace dir: %w", err)
+		}
+	}
+	if cfg.UID >= 0 || cfg.GID >= 0 {
+		if err := os.Chown(cfg.Dir, cfg.UID, cfg.GID); err != nil {
+			return nil, fmt.Errorf("chown trace dir: %w", err)
+		}
+	}
 
 	w := &TSVWriter{
-		dir:      dir,
-		baseName: "traces.tsv",
-		maxSize:  maxSize,
-		maxFiles: maxFiles,
+		dir:      cfg.Dir,
+		baseName: baseName,
+		indexed:  strings.Contains(baseName, "{index}"),
+		maxSize:  cfg.MaxSize,
+		maxFiles: cfg.MaxFiles,
+		fileMode: cfg.FileMode,
+		setMode:  setFileMode,
+		uid:      cfg.UID,
+		gid:      cfg.GID,
+		epoch:    cfg.TimeFormat == TimeFormatEpoch,
+		maxEvent: cfg.MaxEventSize,
 	}
 
 	if err := w.openFile(); err != nil {
@@ -50,20 +144,70 @@ func NewTSVWriter(dir string, maxSize int64, maxFiles int) (*TSVWriter, error) {
 	return w, nil
 }
 
+// validateModes rejects modes with non-permission bits, and modes that would
+// lock the writer itself out (the owner needs rwx on the dir, rw on files).
+func validateModes(dirMode, fileMode os.FileMode) error {
+	if dirMode&^os.ModePerm != 0 || dirMode&0700 != 0700 {
+		return fmt.Errorf("invalid trace dir mode %#o: must be within 0777 and include 0700", uint32(dirMode))
+	}
+	if fileMode&^os.ModePerm != 0 || fileMode&0600 != 0600 {
+		return fmt.Errorf("invalid trace file mode %#o: must be within 0777 and include 0600", uint32(fileMode))
+	}
+	return nil
+}
+
+// expandTemplate substitutes {node} and {date} in a file name template.
+func expandTemplate(tmpl, node string, now time.Time) (string, error) {
+	if tmpl == "" {
+		tmpl = defaultFileTemplate
+	}
+	if strings.Contains(tmpl, "{node}") && node == "" {
+		return "", fmt.Errorf("trace file template %q uses {node} but no node name is set", tmpl)
+	}
+	name := strings.NewReplacer(
+		"{node}", node,
+		"{date}", now.UTC().Format("20060102"),
+	).Replace(tmpl)
+	if strings.ContainsRune(name, '/') || name == "." || name == ".." {
+		return "", fmt.Errorf("trace file template %q must expand to a plain file name, got %q", tmpl, name)
+	}
+	return name, nil
+}
+
 func (w *TSVWriter) activePath() string {
+	if w.indexed {
+		return filepath.Join(w.dir, strings.ReplaceAll(w.baseName, "{index}", "0"))
+	}
 	return filepath.Join(w.dir, w.baseName)
 }
 
 func (w *TSVWriter) rotatedPath(n int) string {
+	if w.indexed {
+		return filepath.Join(w.dir, strings.ReplaceAll(w.baseName, "{index}", strconv.Itoa(n)))
+	}
 	return filepath.Join(w.dir, fmt.Sprintf("%s.%d", w.baseName, n))
 }
 
 func (w *TSVWriter) openFile() error {
 	path := w.activePath()
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	_, statErr := os.Lstat(path)
+	created := errors.Is(statErr, os.ErrNotExist)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, w.fileMode)
 	if err != nil {
 		return fmt.Errorf("open trace file: %w", err)
 	}
+	if created || w.setMode {
+		if err := f.Chmod(w.fileMode); err != nil {
+			f.Close()
+			return fmt.Errorf("chmod trace file: %w", err)
+		}
+	}
+	if w.uid >= 0 || w.gid >= 0 {
+		if err := f.Chown(w.uid, w.gid); err != nil {
+			f.Close()
+			return fmt.Errorf("chown trace file: %w", err)
+		}
+	}
 
 	info, err := f.Stat()
 	if err != nil {
@@ -92,15 +236,20 @@ func (w *TSVWriter) WriteEvent(evt TraceEvent) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	line := fmt.Sprintf("%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
-		evt.Timestamp.Format(time.RFC3339Nano),
-		evt.Pod,
-		evt.Container,
-		evt.CgroupID,
-		evt.Operation,
-		evt.Path,
-		evt.Fstype,
-	)
+	ts := evt.Timestamp.Format(time.RFC3339Nano)
+	if w.epoch {
+		ts = strconv.FormatInt(evt.Timestamp.UnixNano(), 10)
+	}
+	line := formatRow(ts, evt)
+	if w.maxEvent > 0 && len(line) > w.maxEvent {
+		evt.HostPath = ""
+		line = formatRow(ts, evt)
+		if excess := len(line) - w.maxEvent; excess > 0 {
+			evt.Path = truncatePath(evt.Path, len(evt.Path)-excess-len(TruncatedMarker))
+			line = formatRow(ts, evt)
+			w.truncated.Add(1)
+		}
+	}
 
 	n, err := w.buf.WriteString(line)
 	if err != nil {
@@ -117,6 +266,37 @@ func (w *TSVWriter) WriteEvent(evt TraceEvent) error {
 	return nil
 }
 
+// Truncated returns the number of rows whose path was shortened to fit
+// MaxEventSize. Rows that fit once host_path was dropped are not counted.
+func (w *TSVWriter) Truncated() uint64 {
+	return w.truncated.Load()
+}
+
+func formatRow(ts string, evt TraceEvent) string {
+	return fmt.Sprintf("%s\t%s\t%s\t%d\t%s\t%s\t%s\t%d\t%s\t%s\n",
+		ts,
+		evt.Pod,
+		evt.Container,
+		evt.CgroupID,
+		evt.Operation,
+		evt.Path,
+		evt.Fstype,
+		evt.Pid,
+		evt.HostPath,
+		evt.Type,
+	)
+}
+
+// truncatePath keeps at most keep bytes from the end of path, where the
+// file name is, without splitting a UTF-8 sequence, and marks the result.
+func truncatePath(path string, keep int) string {
+	i := len(path) - max(keep, 0)
+	for i < len(path) && !utf8.RuneStart(path[i]) {
+		i++
+	}
+	return TruncatedMarker + path[i:]
+}
+
 func (w *TSVWriter) rotate() error {
 	if err := w.buf.Flush(); err != nil {
 		return fmt.Errorf("flush before rotate: %w", err)
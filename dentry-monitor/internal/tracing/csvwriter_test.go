@@ -0,0 +1,100 @@
+package tracing
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriterModes(t *testing.T) {
+	tests := []struct {
+		name              string
+		existing          bool // dir and file exist with 0750/0640 beforehand
+		dirMode, fileMode os.FileMode
+		wantDir, wantFile os.FileMode
+	}{
+		{"new paths get defaults", false, 0, 0, 0755, 0644},
+		{"existing paths keep their modes", true, 0, 0, 0750, 0640},
+		{"explicit modes are enforced", true, 0700, 0600, 0700, 0600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "traces")
+			file := filepath.Join(dir, defaultFileTemplate)
+			if tt.existing {
+				if err := os.Mkdir(dir, 0750); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(file, nil, 0640); err != nil {
+					t.Fatal(err)
+				}
+				os.Chmod(dir, 0750)
+				os.Chmod(file, 0640)
+			}
+			w, err := NewTSVWriter(WriterConfig{
+				Dir: dir, MaxSize: 1 << 20, MaxFiles: 1, UID: -1, GID: -1,
+				DirMode: tt.dirMode, FileMode: tt.fileMode,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+
+			for path, want := range map[string]os.FileMode{dir: tt.wantDir, file: tt.wantFile} {
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := info.Mode().Perm(); got != want {
+					t.Errorf("%s mode = %#o, want %#o", filepath.Base(path), got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriterTruncation(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewTSVWriter(WriterConfig{Dir: dir, MaxSize: 1 << 20, MaxFiles: 1, UID: -1, GID: -1, MaxEventSize: 256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	long := "/" + strings.Repeat("d", 300)
+	tests := []struct {
+		name          string
+		evt           TraceEvent
+		wantTruncated uint64 // running count after the write
+		wantPath      string
+	}{
+		{"fits", TraceEvent{Path: "/etc/hosts", HostPath: "/proc/1/root/etc/hosts"}, 0, "/etc/hosts"},
+		{"host path dropped", TraceEvent{Path: "/etc/hosts", HostPath: long}, 0, "/etc/hosts"},
+		{"path truncated", TraceEvent{Path: long + "/file"}, 1, ""},
+	}
+	for _, tt := range tests {
+		if err := w.WriteEvent(tt.evt); err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Truncated(); got != tt.wantTruncated {
+			t.Errorf("%s: Truncated() = %d, want %d", tt.name, got, tt.wantTruncated)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	rows := readRows(t, dir)
+	for i, tt := range tests {
+		f := strings.Split(rows[i], "\t")
+		if len(rows[i])+1 > 256 {
+			t.Errorf("%s: row is %d bytes, want <= 256", tt.name, len(rows[i])+1)
+		}
+		switch {
+		case tt.wantPath != "" && f[5] != tt.wantPath:
+			t.Errorf("%s: path = %q, want %q", tt.name, f[5], tt.wantPath)
+		case tt.wantPath == "" && !(strings.HasPrefix(f[5], TruncatedMarker) && strings.HasSuffix(f[5], "/file")):
+			t.Errorf("%s: path = %q, want a %s tail ending in /file", tt.name, f[5], TruncatedMarker)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"sync"
+	"time"
+)
+
+// deduper collapses a d_alloc event and the d_instantiate that follows it
+// with the same event key (by default cgroup and leaf name) into a single
+// event carrying the final (positive/negative) operation. Allocs with no matching instantiate within
+// the window are released unchanged, so output order is relaxed by at most
+// one window for those events.
+type deduper struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	pending map[string]pendingAlloc
+}
+
+type pendingAlloc struct {
+	evt  TraceEvent
+	seen time.Time
+}
+
+func newDeduper(window time.Duration) *deduper {
+	return &deduper{
+		window:  window,
+		pending: make(map[string]pendingAlloc),
+	}
+}
+
+// offer processes one event and returns the events ready to be written.
+// collapsed is true if evt completed a pending alloc.
+func (d *deduper) offer(evt TraceEvent, key string, now time.Time) (out []TraceEvent, collapsed bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	prev, ok := d.pending[key]
+	switch evt.Operation {
+	case "alloc":
+		if ok {
+			out = append(out, prev.evt)
+		}
+		d.pending[key] = pendingAlloc{evt: evt, seen: now}
+		return out, false
+	case "positive", "negative":
+		if !ok {
+			return []TraceEvent{evt}, false
+		}
+		delete(d.pending, key)
+		if now.Sub(prev.seen) <= d.window {
+			return []TraceEvent{evt}, true
+		}
+		return []TraceEvent{prev.evt, evt}, false
+	default:
+		return []TraceEvent{evt}, false
+	}
+}
+
+// expire releases allocs that have waited longer than the window.
+func (d *deduper) expire(now time.Time) []TraceEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var out []TraceEvent
+	for key, p := range d.pending {
+		if now.Sub(p.seen) > d.window {
+			out = append(out, p.evt)
+			delete(d.pending, key)
+		}
+	}
+	return out
+}
+
+// drain releases every pending alloc (used on shutdown).
+func (d *deduper) drain() []TraceEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	out := make([]TraceEvent, 0, len(d.pending))
+	for key, p := range d.pending {
+		out = append(out, p.evt)
+		delete(d.pending, key)
+	}
+	return out
+}
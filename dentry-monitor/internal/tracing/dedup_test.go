@@ -0,0 +1,124 @@
+package tracing
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeduper(t *testing.T) {
+	const window = 5 * time.Millisecond
+	ev := func(cg uint64, op, path string) TraceEvent {
+		return TraceEvent{CgroupID: cg, Operation: op, Path: path}
+	}
+	// A step either offers evt, or (evt.Operation "expire"/"drain") calls
+	// that method. want lists "op path" of the events returned.
+	type step struct {
+		at        time.Duration // clock offset from t0
+		evt       TraceEvent
+		want      []string
+		collapsed bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"pair within window collapses", []step{
+			{0, ev(1, "alloc", "/a/x"), nil, false},
+			{window, ev(1, "negative", "/a/x"), []string{"negative /a/x"}, true},
+		}},
+		{"pair past window is kept apart", []step{
+			{0, ev(1, "alloc", "/a/x"), nil, false},
+			{window + 1, ev(1, "positive", "/a/x"), []string{"alloc /a/x", "positive /a/x"}, false},
+		}},
+		{"expire releases only allocs older than the window", []step{
+			{0, ev(1, "alloc", "/a/x"), nil, false},
+			{3 * time.Millisecond, ev(1, "alloc", "/a/y"), nil, false},
+			{window, ev(0, "expire", ""), nil, false},
+			{window + 1, ev(0, "expire", ""), []string{"alloc /a/x"}, false},
+			{window + 1, ev(1, "negative", "/a/y"), []string{"negative /a/y"}, true},
+		}},
+		{"drain releases every pending alloc", []step{
+			{0, ev(1, "alloc", "/a/x"), nil, false},
+			{0, ev(2, "alloc", "/a/x"), nil, false},
+			{time.Millisecond, ev(0, "drain", ""), []string{"alloc /a/x", "alloc /a/x"}, false},
+			{time.Millisecond, ev(1, "negative", "/a/x"), []string{"negative /a/x"}, false},
+		}},
+		{"instantiate without alloc passes through", []step{
+			{0, ev(1, "negative", "/a/x"), []string{"negative /a/x"}, false},
+		}},
+		{"reclaim markers pass through", []step{
+			{0, ev(1, "alloc", "/a/x"), nil, false},
+			{0, ev(1, "reclaim", ""), []string{"reclaim "}, false},
+			{0, ev(1, "negative", "/a/x"), []string{"negative /a/x"}, true},
+		}},
+		{"unlinked allocs in one cgroup collide on the leaf key", []step{
+			{0, ev(1, "alloc", UnlinkedPath), nil, false},
+			{0, ev(1, "alloc", UnlinkedPath), []string{"alloc " + UnlinkedPath}, false},
+			{0, ev(1, "positive", UnlinkedPath), []string{"positive " + UnlinkedPath}, true},
+			{0, ev(0, "drain", ""), []string{}, false},
+		}},
+		{"leaf key pairs across directories, not cgroups", []step{
+			{0, ev(1, "alloc", "/a/x"), nil, false},
+			{0, ev(2, "negative", "/a/x"), []string{"negative /a/x"}, false},
+			{0, ev(1, "negative", "/b/x"), []string{"negative /b/x"}, true},
+		}},
+	}
+	key, err := eventKeyFunc("leaf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Unix(1700000000, 0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDeduper(window)
+			for i, s := range tt.steps {
+				now := t0.Add(s.at)
+				var out []TraceEvent
+				var collapsed bool
+				switch s.evt.Operation {
+				case "expire":
+					out = d.expire(now)
+				case "drain":
+					out = d.drain()
+				default:
+					out, collapsed = d.offer(s.evt, key(s.evt), now)
+				}
+				got := []string{}
+				for _, e := range out {
+					got = append(got, fmt.Sprintf("%s %s", e.Operation, e.Path))
+				}
+				want := s.want
+				if want == nil {
+					want = []string{}
+				}
+				if !reflect.DeepEqual(got, want) || collapsed != s.collapsed {
+					t.Errorf("step %d: got %v collapsed=%v, want %v collapsed=%v",
+						i, got, collapsed, want, s.collapsed)
+				}
+			}
+		})
+	}
+}
+
+// TestCloseFlushesPendingAllocs checks that allocs still waiting for their
+// instantiate when the consumer closes are written, not lost.
+func TestCloseFlushesPendingAllocs(t *testing.T) {
+	c, dir := newTestConsumer(t, TraceConfig{PollTimeout: 10 * time.Millisecond, DedupWindow: time.Hour})
+	stopCh := make(chan struct{})
+	go c.Start(stopCh)
+	waitStarted(t, c)
+
+	c.dispatch(rawOpSample(t, 1, OpAlloc, "pending"))
+	close(stopCh)
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	rows := readRows(t, dir)
+	if len(rows) != 1 || !strings.Contains(rows[0], "\talloc\t/pending\t") {
+		t.Errorf("rows = %q, want the pending alloc", rows)
+	}
+}
@@ -0,0 +1,32 @@
+package tracing
+
+// dropReason enumerates why the consumer discarded an event. The set is
+// fixed so dentry_trace_dropped_total has bounded cardinality.
+type dropReason int
+
+const (
+	dropParseError dropReason = iota
+	dropCgroupFilter
+	dropPatternMismatch
+	dropSampledOut
+	dropTypeFilter
+	dropWriteError
+	dropQueueFull
+	dropOperationFilter
+	numDropReasons
+)
+
+var dropReasonNames = [numDropReasons]string{
+	dropParseError:      "parse_error",
+	dropCgroupFilter:    "cgroup_filter",
+	dropPatternMismatch: "pattern_mismatch",
+	dropSampledOut:      "sampled_out",
+	dropTypeFilter:      "type_filter",
+	dropWriteError:      "write_error",
+	dropQueueFull:       "queue_full",
+	dropOperationFilter: "operation_filter",
+}
+
+func (c *Consumer) drop(reason dropReason) {
+	c.drops[reason].Add(1)
+}
@@ -0,0 +1,31 @@
+package tracing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EventKeys lists the values accepted for TraceConfig.EventKey. Both name a
+// single dentry within one cgroup, so dedup never pairs events of different
+// containers:
+//
+//	leaf         cgroup ID and file name (the default)
+//	cgroup+path  cgroup ID and full path
+var EventKeys = []string{"leaf", "cgroup+path"}
+
+// eventKeyFunc returns the function that groups events for the given key
+// name. An empty name selects "leaf".
+func eventKeyFunc(name string) (func(TraceEvent) string, error) {
+	switch name {
+	case "", "leaf":
+		return func(e TraceEvent) string {
+			return strconv.FormatUint(e.CgroupID, 10) + "|" + e.Path[strings.LastIndexByte(e.Path, '/')+1:]
+		}, nil
+	case "cgroup+path":
+		return func(e TraceEvent) string {
+			return strconv.FormatUint(e.CgroupID, 10) + "|" + e.Path
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown event key %q: must be one of %v", name, EventKeys)
+}
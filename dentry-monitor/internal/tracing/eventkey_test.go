@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventKeyGrouping(t *testing.T) {
+	base := TraceEvent{CgroupID: 1, Path: "/etc/ssl/certs.pem"}
+	others := map[string]TraceEvent{
+		"other cgroup":    {CgroupID: 2, Path: "/etc/ssl/certs.pem"},
+		"other dir":       {CgroupID: 1, Path: "/tmp/certs.pem"},
+		"sibling in dir":  {CgroupID: 1, Path: "/etc/ssl/openssl.cnf"},
+		"same everything": {CgroupID: 1, Path: "/etc/ssl/certs.pem"},
+	}
+	// Which of others share base's key, per event key.
+	want := map[string][]string{
+		"leaf":        {"other dir", "same everything"},
+		"cgroup+path": {"same everything"},
+	}
+	for _, key := range EventKeys {
+		t.Run(key, func(t *testing.T) {
+			fn, err := eventKeyFunc(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			same := map[string]bool{}
+			for _, name := range want[key] {
+				same[name] = true
+			}
+			for name, evt := range others {
+				if got := fn(evt) == fn(base); got != same[name] {
+					t.Errorf("%s: same key = %v, want %v", name, got, same[name])
+				}
+			}
+		})
+	}
+}
+
+func TestEventKeyUnknown(t *testing.T) {
+	for _, key := range []string{"path", "dir", "inode"} {
+		if _, err := eventKeyFunc(key); err == nil {
+			t.Errorf("eventKeyFunc(%q) accepted an unknown key", key)
+		}
+	}
+}
+
+// TestDedupByEventKey checks that an alloc is only collapsed into an
+// instantiate of the same dentry under each dedup-capable key.
+func TestDedupByEventKey(t *testing.T) {
+	alloc := TraceEvent{CgroupID: 1, Operation: "alloc", Path: "/a/x"}
+	tests := []struct {
+		key      string
+		inst     TraceEvent
+		collapse bool
+	}{
+		{"leaf", TraceEvent{CgroupID: 1, Operation: "negative", Path: "/a/x"}, true},
+		{"leaf", TraceEvent{CgroupID: 2, Operation: "negative", Path: "/a/x"}, false},
+		{"cgroup+path", TraceEvent{CgroupID: 1, Operation: "negative", Path: "/a/x"}, true},
+		{"cgroup+path", TraceEvent{CgroupID: 1, Operation: "negative", Path: "/b/x"}, false},
+	}
+	for _, tt := range tests {
+		fn, err := eventKeyFunc(tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := newDeduper(time.Second)
+		now := time.Now()
+		d.offer(alloc, fn(alloc), now)
+		_, collapsed := d.offer(tt.inst, fn(tt.inst), now)
+		if collapsed != tt.collapse {
+			t.Errorf("%s: %d %s collapsed = %v, want %v", tt.key, tt.inst.CgroupID, tt.inst.Path, collapsed, tt.collapse)
+		}
+	}
+}
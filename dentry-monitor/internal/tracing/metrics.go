@@ -0,0 +1,139 @@
+package tracing
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pipelineMetrics describes the trace-pipeline metrics exposed by Consumer.
+type pipelineMetrics struct {
+	dedupDesc *prometheus.Desc
+	opDesc    *prometheus.Desc
+	classDesc *prometheus.Desc
+
+	enabledDesc  *prometheus.Desc
+	patternsDesc *prometheus.Desc
+	ttlDesc      *prometheus.Desc
+	sampleDesc   *prometheus.Desc
+	dropDesc     *prometheus.Desc
+	queueDesc    *prometheus.Desc
+	truncDesc    *prometheus.Desc
+}
+
+// newPipelineMetrics builds the descriptors with names starting with prefix
+// and the given constant labels.
+func newPipelineMetrics(prefix string, labels prometheus.Labels) pipelineMetrics {
+	return pipelineMetrics{
+		dedupDesc: prometheus.NewDesc(
+			prefix+"_trace_dedup_collapsed_total",
+			"Alloc+instantiate event pairs collapsed into a single trace event",
+			nil, labels,
+		),
+		opDesc: prometheus.NewDesc(
+			prefix+"_trace_events_received_total",
+			"Trace events read from the ring buffer by operation, before any userspace filtering",
+			[]string{"operation"}, labels,
+		),
+		classDesc: prometheus.NewDesc(
+			prefix+"_trace_path_class_total",
+			"Trace events whose path matches a configured path class, by class and operation",
+			[]string{"path_class", "operation"}, labels,
+		),
+		enabledDesc: prometheus.NewDesc(
+			prefix+"_trace_enabled",
+			"1 if path tracing is enabled in the live trace config",
+			nil, labels,
+		),
+		patternsDesc: prometheus.NewDesc(
+			prefix+"_trace_pattern_count",
+			"Number of active trace path patterns by kind (include, exclude)",
+			[]string{"kind"}, labels,
+		),
+		dropDesc: prometheus.NewDesc(
+			prefix+"_trace_dropped_total",
+			"Trace events discarded by the consumer, by reason",
+			[]string{"reason"}, labels,
+		),
+		queueDesc: prometheus.NewDesc(
+			prefix+"_trace_queue_depth",
+			"Trace events read from the ring buffer and waiting for a worker",
+			nil, labels,
+		),
+		truncDesc: prometheus.NewDesc(
+			prefix+"_trace_truncated_total",
+			"Trace rows whose path was shortened to fit -trace-max-event-size",
+			nil, labels,
+		),
+		sampleDesc: prometheus.NewDesc(
+			prefix+"_trace_sample_rate",
+			"Fraction of trace events kept after sampling, by operation",
+			[]string{"operation"}, labels,
+		),
+		ttlDesc: prometheus.NewDesc(
+			prefix+"_trace_disable_in_seconds",
+			"Seconds until -trace-disable-after turns tracing off (0 = no TTL pending)",
+			nil, labels,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Consumer) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.metrics.dedupDesc
+	ch <- c.metrics.opDesc
+	ch <- c.metrics.classDesc
+	ch <- c.metrics.enabledDesc
+	ch <- c.metrics.patternsDesc
+	ch <- c.metrics.ttlDesc
+	ch <- c.metrics.sampleDesc
+	ch <- c.metrics.dropDesc
+	ch <- c.metrics.queueDesc
+	ch <- c.metrics.truncDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *Consumer) Collect(ch chan<- prometheus.Metric) {
+	cfg := c.Config()
+	var enabled float64
+	if cfg.Enabled {
+		enabled = 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.metrics.enabledDesc, prometheus.GaugeValue, enabled)
+	ch <- prometheus.MustNewConstMetric(c.metrics.patternsDesc, prometheus.GaugeValue,
+		float64(len(cfg.PathPatterns)), "include")
+	ch <- prometheus.MustNewConstMetric(c.metrics.patternsDesc, prometheus.GaugeValue,
+		float64(len(cfg.ExcludePatterns)), "exclude")
+	ch <- prometheus.MustNewConstMetric(c.metrics.ttlDesc, prometheus.GaugeValue,
+		c.DisableIn().Seconds())
+	for op := uint32(0); op < OpReclaim; op++ {
+		ch <- prometheus.MustNewConstMetric(c.metrics.sampleDesc, prometheus.GaugeValue,
+			c.rates[op], opName(op))
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.metrics.dedupDesc, prometheus.CounterValue,
+		float64(c.collapsed.Load()))
+
+	for op := uint32(0); op < numOps; op++ {
+		ch <- prometheus.MustNewConstMetric(c.metrics.opDesc, prometheus.CounterValue,
+			float64(c.opCounts[op].Load()), opName(op))
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.metrics.queueDesc, prometheus.GaugeValue,
+		float64(c.queueDepth()))
+	var truncated uint64
+	if c.writer != nil {
+		truncated = c.writer.Truncated()
+	}
+	ch <- prometheus.MustNewConstMetric(c.metrics.truncDesc, prometheus.CounterValue,
+		float64(truncated))
+	for r := dropReason(0); r < numDropReasons; r++ {
+		ch <- prometheus.MustNewConstMetric(c.metrics.dropDesc, prometheus.CounterValue,
+			float64(c.drops[r].Load()), dropReasonNames[r])
+	}
+
+	for i, pc := range c.classes.classes {
+		for op := uint32(0); op < OpReclaim; op++ {
+			ch <- prometheus.MustNewConstMetric(c.metrics.classDesc, prometheus.CounterValue,
+				float64(c.classes.counts[i][op].Load()), pc.Name, opName(op))
+		}
+	}
+}
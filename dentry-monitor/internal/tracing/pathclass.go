@@ -0,0 +1,69 @@
+package tracing
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// MaxPathClasses bounds the number of path classes, and with it the
+// cardinality of the path class metric.
+const MaxPathClasses = 16
+
+// PathClass names a path substring whose events are counted in the
+// path_class metric, e.g. {Name: "cache-miss", Pattern: "/cache/"}.
+type PathClass struct {
+	Name    string
+	Pattern string
+}
+
+// ParsePathClasses parses "name=pattern,name2=pattern2".
+func ParsePathClasses(s string) ([]PathClass, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var classes []PathClass
+	seen := make(map[string]bool)
+	for _, pair := range strings.Split(s, ",") {
+		name, pattern, ok := strings.Cut(pair, "=")
+		if !ok || name == "" || pattern == "" {
+			return nil, fmt.Errorf("%q: expected name=pattern", pair)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate path class %q", name)
+		}
+		seen[name] = true
+		classes = append(classes, PathClass{Name: name, Pattern: pattern})
+	}
+	if len(classes) > MaxPathClasses {
+		return nil, fmt.Errorf("%d path classes, at most %d allowed", len(classes), MaxPathClasses)
+	}
+	return classes, nil
+}
+
+// pathClassCounts counts events per class and operation. An event is
+// counted in the first class, in configuration order, whose pattern it
+// contains, so classes never double-count an event.
+type pathClassCounts struct {
+	classes []PathClass
+	counts  [][numOps]atomic.Uint64
+}
+
+func newPathClassCounts(classes []PathClass) *pathClassCounts {
+	return &pathClassCounts{
+		classes: classes,
+		counts:  make([][numOps]atomic.Uint64, len(classes)),
+	}
+}
+
+func (p *pathClassCounts) observe(path string, op uint32) {
+	if op >= numOps {
+		op = opUnknown
+	}
+	for i, pc := range p.classes {
+		if containsSubstring(path, pc.Pattern) {
+			p.counts[i][op].Add(1)
+			return
+		}
+	}
+}
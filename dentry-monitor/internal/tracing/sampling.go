@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+// ParseSampleRates parses "alloc=0.01,negative=1" into per-operation rates.
+// Only alloc, positive and negative can be sampled; rates must be in [0, 1].
+func ParseSampleRates(s string) (map[string]float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	rates := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		op, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: expected operation=rate", pair)
+		}
+		if op != "alloc" && op != "positive" && op != "negative" {
+			return nil, fmt.Errorf("%q: operation must be alloc, positive or negative", op)
+		}
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("%q: rate must be a number in [0, 1]", pair)
+		}
+		rates[op] = rate
+	}
+	return rates, nil
+}
+
+// sampleRates resolves the configured rates per operation index. Operations
+// without an entry use def; reclaim markers are never sampled.
+func sampleRates(rates map[string]float64, def float64) [numOps]float64 {
+	var out [numOps]float64
+	for op := uint32(0); op < numOps; op++ {
+		out[op] = def
+		if r, ok := rates[opName(op)]; ok {
+			out[op] = r
+		}
+	}
+	out[OpReclaim] = 1
+	return out
+}
+
+// sampled reports whether an event of op survives sampling.
+func (c *Consumer) sampled(op uint32) bool {
+	if op >= numOps {
+		op = opUnknown
+	}
+	rate := c.rates[op]
+	return rate >= 1 || rand.Float64() < rate
+}
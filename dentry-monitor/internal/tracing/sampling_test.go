@@ -0,0 +1,97 @@
+package tracing
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParseSampleRates(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]float64
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"alloc=0.01", map[string]float64{"alloc": 0.01}, false},
+		{"alloc=0,negative=1", map[string]float64{"alloc": 0, "negative": 1}, false},
+		{"alloc", nil, true},
+		{"reclaim=0.5", nil, true},
+		{"alloc=1.5", nil, true},
+		{"alloc=-0.1", nil, true},
+		{"alloc=x", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSampleRates(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSampleRates(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSampleRates(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+// gaugeValues returns the values of desc's metrics emitted by c, keyed by
+// the first label value.
+func gaugeValues(t *testing.T, c prometheus.Collector, desc *prometheus.Desc) map[string]float64 {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 256)
+	c.Collect(ch)
+	close(ch)
+	out := map[string]float64{}
+	for m := range ch {
+		if m.Desc() != desc {
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		var label string
+		if len(pb.GetLabel()) > 0 {
+			label = pb.GetLabel()[0].GetValue()
+		}
+		out[label] = pb.GetGauge().GetValue()
+	}
+	return out
+}
+
+func TestSampleRates(t *testing.T) {
+	tests := []struct {
+		name  string
+		rates map[string]float64
+		def   float64
+		want  map[string]float64 // _trace_sample_rate by operation
+	}{
+		{"zero default keeps all", nil, 0, map[string]float64{"alloc": 1, "positive": 1, "negative": 1}},
+		{"default applies to every operation", nil, 0.5, map[string]float64{"alloc": 0.5, "positive": 0.5, "negative": 0.5}},
+		{"per-operation overrides", map[string]float64{"alloc": 0.01, "negative": 1}, 0.5,
+			map[string]float64{"alloc": 0.01, "positive": 0.5, "negative": 1}},
+		{"per-operation zero drops", map[string]float64{"alloc": 0}, 0, map[string]float64{"alloc": 0, "positive": 1, "negative": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestConsumer(t, TraceConfig{SampleRate: tt.def, SampleRates: tt.rates})
+			if got := gaugeValues(t, c, c.metrics.sampleDesc); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("_trace_sample_rate = %v, want %v", got, tt.want)
+			}
+			if !c.sampled(OpReclaim) {
+				t.Error("reclaim marker was sampled out")
+			}
+			for op := uint32(0); op < OpReclaim; op++ {
+				switch rate := tt.want[opName(op)]; rate {
+				case 0, 1:
+					for i := 0; i < 100; i++ {
+						if c.sampled(op) != (rate == 1) {
+							t.Fatalf("%s at rate %v: sampled = %v", opName(op), rate, !(rate == 1))
+						}
+					}
+				}
+			}
+		})
+	}
+}